@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	acpiTablesDir    = "/sys/firmware/acpi/tables"
+	acpiHeaderLength = 36
+)
+
+// acpiHeader is the common System Description Table header
+type acpiHeader struct {
+	Signature       string
+	Length          uint32
+	Revision        byte
+	OEMID           string
+	OEMTableID      string
+	OEMRevision     uint32
+	CreatorID       string
+	CreatorRevision uint32
+}
+
+func parseACPIHeader(data []byte) (acpiHeader, bool) {
+	if len(data) < acpiHeaderLength {
+		return acpiHeader{}, false
+	}
+	trim := func(b []byte) string { return strings.TrimRight(string(b), " \x00") }
+	return acpiHeader{
+		Signature:       trim(data[0:4]),
+		Length:          binary.LittleEndian.Uint32(data[4:]),
+		Revision:        data[8],
+		OEMID:           trim(data[10:16]),
+		OEMTableID:      trim(data[16:24]),
+		OEMRevision:     binary.LittleEndian.Uint32(data[24:]),
+		CreatorID:       trim(data[28:32]),
+		CreatorRevision: binary.LittleEndian.Uint32(data[32:]),
+	}, true
+}
+
+// Tables whose headers identify the firmware, WAET only exists on hypervisors
+var acpiFingerprintTables = []string{"DSDT", "FACP", "APIC", "SRAT", "WAET", "HPET", "MCFG"}
+
+// acpiFingerprints match OEM IDs, OEM table IDs and creator IDs to the firmware that built them
+var acpiFingerprints = []struct {
+	Needle     string
+	Product    string
+	Hypervisor string
+}{
+	{"BOCHS", "QEMU", "kvm"},
+	{"BXPC", "QEMU", "kvm"},
+	{"FIRECK", "Firecracker", "kvm"},
+	{"FCAT", "Firecracker", "kvm"},
+	{"CLOUDH", "Cloud Hypervisor", "kvm"},
+	{"CHYP", "Cloud Hypervisor", "kvm"},
+	{"AMAZON", "Amazon EC2 Nitro", "kvm"},
+	{"AMZN", "Amazon EC2 Nitro", "kvm"},
+	{"GOOG", "Google Compute Engine", "kvm"},
+	{"VBOX", "VirtualBox", "virtualbox"},
+	{"HVML", "Xen", "xen"},
+	{"XEN", "Xen", "xen"},
+	{"VRTUAL", "Microsoft Hyper-V", "hyperv"},
+	{"VMWARE", "VMware", "vmware"},
+	{"VMW", "VMware", "vmware"},
+	{"PRLS", "Parallels", "parallels"},
+	{"PARALLEL", "Parallels", "parallels"},
+}
+
+// readACPITable returns the first n bytes of a table, or all of it when n is 0
+func readACPITable(signature string, n int64) ([]byte, error) {
+	file, err := os.Open(filepath.Join(acpiTablesDir, signature))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if n == 0 {
+		return io.ReadAll(file)
+	}
+	return io.ReadAll(io.LimitReader(file, n))
+}
+
+// readACPIHeaders reads the headers of the fingerprinted tables that exist
+func readACPIHeaders() []acpiHeader {
+	var headers []acpiHeader
+	for _, signature := range acpiFingerprintTables {
+		data, err := readACPITable(signature, acpiHeaderLength)
+		if err != nil {
+			continue
+		}
+		if header, ok := parseACPIHeader(data); ok {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// matchACPIFingerprint returns the product and hypervisor a table header was built by
+func matchACPIFingerprint(header acpiHeader) (string, string) {
+	for _, field := range []string{header.OEMID, header.OEMTableID, header.CreatorID} {
+		upper := strings.ToUpper(field)
+		for _, fp := range acpiFingerprints {
+			if strings.HasPrefix(upper, fp.Needle) {
+				return fp.Product, fp.Hypervisor
+			}
+		}
+	}
+	return "", ""
+}
+
+// acpiHypervisor identifies the hypervisor from the ACPI table headers. Xen HVM guests can carry
+// QEMU built tables next to hvmloader's, so Xen wins.
+func acpiHypervisor() (string, string) {
+	var found, evidence string
+	for _, header := range readACPIHeaders() {
+		product, hv := matchACPIFingerprint(header)
+		if hv == "" {
+			continue
+		}
+		description := header.Signature + " " + header.OEMID + "/" + header.OEMTableID + "/" + header.CreatorID + " (" + product + ")"
+		if hv == "xen" {
+			return hv, description
+		}
+		if found == "" {
+			found, evidence = hv, description
+		}
+	}
+	return found, evidence
+}
+
+// hasWAET reports the Windows ACPI Emulated devices Table, which only hypervisors provide
+func hasWAET() bool {
+	_, err := os.Stat(filepath.Join(acpiTablesDir, "WAET"))
+	return err == nil
+}
+
+// AML encoding of DWordConst EisaId("PNP0A08") (PCI Express root bridge) and EisaId("PNP0A03") (PCI root bridge)
+var (
+	amlPNP0A08 = []byte{0x0c, 0x41, 0xd0, 0x0a, 0x08}
+	amlPNP0A03 = []byte{0x0c, 0x41, 0xd0, 0x0a, 0x03}
+)
+
+// qemuMachineType tells q35 (PCIe host bridge and MCFG) and i440fx (legacy PCI host bridge) apart.
+// Other VMMs (Firecracker, Cloud Hypervisor) also provide MCFG, so only QEMU built tables count.
+func qemuMachineType() string {
+	dsdt, err := readACPITable("DSDT", 0)
+	if err != nil {
+		return ""
+	}
+	header, ok := parseACPIHeader(dsdt)
+	if product, _ := matchACPIFingerprint(header); !ok || product != "QEMU" {
+		return ""
+	}
+	_, mcfgErr := os.Stat(filepath.Join(acpiTablesDir, "MCFG"))
+	switch {
+	case bytes.Contains(dsdt, amlPNP0A08) || mcfgErr == nil:
+		return "q35"
+	case bytes.Contains(dsdt, amlPNP0A03):
+		return "i440fx"
+	}
+	return ""
+}
+
+// ACPIInventory logs the header of every fingerprinted table
+func ACPIInventory() {
+	for _, header := range readACPIHeaders() {
+		product, _ := matchACPIFingerprint(header)
+		if product == "" {
+			product = "unknown firmware"
+		}
+		log.Printf("[VM][ACPI] %s rev %d OEM %q table %q rev %#x creator %q rev %#x: %s",
+			header.Signature, header.Revision, header.OEMID, header.OEMTableID, header.OEMRevision,
+			header.CreatorID, header.CreatorRevision, product)
+	}
+	if hasWAET() {
+		log.Println("[VM][ACPI] WAET table present, firmware was built by a hypervisor")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var cloudInitConfigFiles = []string{
+	"/etc/cloud/cloud.cfg",
+	"/etc/cloud/cloud.cfg.d/*.cfg",
+}
+
+// User-data, vendor-data and seeds, which only root should be able to read
+var cloudInitDataFiles = []string{
+	"/var/lib/cloud/instance/user-data.txt",
+	"/var/lib/cloud/instance/user-data.txt.i",
+	"/var/lib/cloud/instance/vendor-data.txt",
+	"/var/lib/cloud/instance/vendor-data.txt.i",
+	"/var/lib/cloud/instance/vendor-data2.txt",
+	"/var/lib/cloud/instance/vendor-data2.txt.i",
+	"/var/lib/cloud/instances/*/user-data.txt",
+	"/var/lib/cloud/instances/*/vendor-data.txt",
+	"/var/lib/cloud/seed/*/*",
+}
+
+var cloudInitLogFiles = []string{
+	"/var/log/cloud-init.log",
+	"/var/log/cloud-init-output.log",
+}
+
+// Files provisioned by the provider rather than the customer
+var cloudInitProviderFiles = []string{
+	"/etc/cloud/cloud.cfg",
+	"/etc/cloud/cloud.cfg.d/*.cfg",
+	"/var/lib/cloud/instance/vendor-data.txt",
+	"/var/lib/cloud/instance/vendor-data2.txt",
+	"/var/lib/cloud/instances/*/vendor-data.txt",
+}
+
+var (
+	passwordHashRegex  = regexp.MustCompile(`\$(1|2[abxy]?|5|6|y|gy|7)\$[./A-Za-z0-9$=+]{8,}`)
+	yamlKeyValueRegex  = regexp.MustCompile(`^(\s*)-?\s*([A-Za-z_]+)\s*:\s*(.*)$`)
+	chpasswdEntryRegex = regexp.MustCompile(`^\s*-?\s*([A-Za-z0-9_.-]+):([^\s{]\S*)\s*$`)
+	sshKeyRegex        = regexp.MustCompile(`(ssh-(rsa|ed25519|dss)|ecdsa-sha2-nistp\d+|sk-ssh-ed25519@openssh.com) [A-Za-z0-9+/=]+`)
+)
+
+// redactSecret keeps just enough of a secret to recognise it in the report
+func redactSecret(secret string) string {
+	secret = strings.Trim(strings.TrimSpace(secret), `"'`)
+	if m := passwordHashRegex.FindString(secret); m != "" {
+		return m[:strings.Index(m[1:], "$")+2] + "<redacted>"
+	}
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return secret[:2] + strings.Repeat("*", 6)
+}
+
+// expandPaths resolves glob patterns to existing files
+func expandPaths(patterns []string) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() && !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files
+}
+
+// scanCloudConfig looks for credentials in a cloud-config style file, following enough of the
+// YAML structure to know which user a hash belongs to and when a chpasswd list starts
+func scanCloudConfig(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	findings := 0
+	report := func(lineNo int, what, value string) {
+		log.Printf("[CloudInit][Secrets] WARNING: %s:%d %s: %s", path, lineNo, what, redactSecret(value))
+		findings++
+	}
+
+	currentUser := ""
+	chpasswdIndent := -1
+	randomPasswords := false
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// cloud-init-output.log prints generated passwords after this header
+		if strings.Contains(line, "random' passwords") || strings.Contains(line, "random\" passwords") {
+			randomPasswords = true
+			continue
+		}
+		if randomPasswords {
+			if m := chpasswdEntryRegex.FindStringSubmatch(line); m != nil {
+				report(lineNo, "generated password for "+m[1], m[2])
+				continue
+			}
+			randomPasswords = false
+		}
+
+		m := yamlKeyValueRegex.FindStringSubmatch(line)
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if chpasswdIndent >= 0 && indent <= chpasswdIndent && m != nil {
+			chpasswdIndent = -1
+		}
+		if chpasswdIndent >= 0 {
+			if entry := chpasswdEntryRegex.FindStringSubmatch(line); entry != nil && entry[2] != "|" {
+				if passwordHashRegex.MatchString(entry[2]) {
+					report(lineNo, "chpasswd password hash for "+entry[1], entry[2])
+				} else {
+					report(lineNo, "chpasswd plaintext password for "+entry[1], entry[2])
+				}
+				continue
+			}
+		}
+		if m == nil {
+			continue
+		}
+
+		key, value := strings.ToLower(m[2]), strings.Trim(strings.TrimSpace(m[3]), `"'`)
+		switch key {
+		case "name":
+			currentUser = value
+		case "chpasswd":
+			chpasswdIndent = indent
+			log.Printf("[CloudInit][Passwords] %s:%d chpasswd module configured", path, lineNo)
+		case "password", "passwd", "plain_text_passwd", "hashed_passwd":
+			if value == "" || value == "|" {
+				continue
+			}
+			owner := "default user"
+			if currentUser != "" {
+				owner = currentUser
+			}
+			switch {
+			case passwordHashRegex.MatchString(value) && owner == "root":
+				report(lineNo, "root password hash", value)
+			case passwordHashRegex.MatchString(value):
+				report(lineNo, "password hash for "+owner, value)
+			default:
+				report(lineNo, "plaintext password for "+owner, value)
+			}
+		case "ssh_pwauth":
+			if v := strings.ToLower(value); v == "true" || v == "yes" || v == "1" || v == "unchanged" {
+				log.Printf("[CloudInit][SSH] WARNING: %s:%d ssh_pwauth is %s (SSH password authentication enabled)", path, lineNo, value)
+				findings++
+			}
+		}
+	}
+
+	return findings
+}
+
+// CheckCloudInitSecrets scans cloud-init configuration, user-data, vendor-data and logs for credentials
+func CheckCloudInitSecrets() bool {
+	var files []string
+	for _, paths := range [][]string{cloudInitConfigFiles, cloudInitDataFiles, cloudInitLogFiles} {
+		files = append(files, expandPaths(paths)...)
+	}
+	if len(files) == 0 {
+		log.Println("[CloudInit][Secrets] No cloud-init files found")
+		return true
+	}
+
+	findings := 0
+	for _, file := range files {
+		findings += scanCloudConfig(file)
+	}
+
+	if findings > 0 {
+		log.Printf("[CloudInit][Secrets] Found %d credential/password settings in cloud-init files", findings)
+		return false
+	}
+	log.Println("[CloudInit][Secrets] No credentials found in cloud-init files")
+	return true
+}
+
+// CheckCloudInitProviderKeys reports SSH keys from provider-controlled cloud-init files that were
+// installed into authorized_keys, giving the provider login access to the VM
+func CheckCloudInitProviderKeys() bool {
+	providerKeys := make(map[string]string)
+	for _, file := range expandPaths(cloudInitProviderFiles) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, key := range sshKeyRegex.FindAllString(string(data), -1) {
+			providerKeys[key] = file
+		}
+	}
+
+	authorizedKeys := expandPaths([]string{"/root/.ssh/authorized_keys", "/home/*/.ssh/authorized_keys"})
+	injected := 0
+	for _, file := range authorizedKeys {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for lineNo, line := range strings.Split(string(data), "\n") {
+			key := sshKeyRegex.FindString(line)
+			if key == "" {
+				continue
+			}
+			if source, ok := providerKeys[key]; ok {
+				// The comment is optional and follows the key, options before it may contain spaces
+				comment := strings.TrimSpace(line[strings.Index(line, key)+len(key):])
+				if comment == "" {
+					comment = "no comment"
+				}
+				log.Printf("[CloudInit][SSHKeys] WARNING: %s:%d contains a provider key from %s (%s)", file, lineNo+1, source, comment)
+				injected++
+			}
+		}
+	}
+
+	if injected > 0 {
+		return false
+	}
+	log.Println("[CloudInit][SSHKeys] No provider-injected SSH keys found in authorized_keys")
+	return true
+}
+
+// CheckCloudInitPermissions flags user-data, vendor-data and seed files other users can read
+func CheckCloudInitPermissions() bool {
+	readable := 0
+	for _, file := range expandPaths(append(cloudInitDataFiles, cloudInitLogFiles...)) {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if info.Mode().Perm()&0004 != 0 {
+			log.Printf("[CloudInit][Permissions] WARNING: %s is world-readable (%s)", file, info.Mode().Perm())
+			readable++
+		}
+	}
+
+	if readable > 0 {
+		return false
+	}
+	log.Println("[CloudInit][Permissions] cloud-init data files are not world-readable")
+	return true
+}
+
+// HasCloudInit reports whether cloud-init has been installed or has run on this host
+func HasCloudInit() bool {
+	for _, path := range []string{"/etc/cloud", "/var/lib/cloud"} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func RunCloudInitChecks() bool {
+	secretsCheck := CheckCloudInitSecrets()
+	keysCheck := CheckCloudInitProviderKeys()
+	permissionsCheck := CheckCloudInitPermissions()
+
+	allPassed := secretsCheck && keysCheck && permissionsCheck
+	if allPassed {
+		log.Println("[CloudInit][Summary] All security checks PASSED")
+	} else {
+		log.Println("[CloudInit][Summary] Some security checks FAILED - review warnings above")
+	}
+	return allPassed
+}
@@ -4,7 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
+	"strings"
 )
 
 type HostileConfig struct {
@@ -17,13 +19,31 @@ type HostileConfig struct {
 	Ipv4      bool
 	Ipv6      bool
 	IP        string
+	// Ports probed on neighbours over IPv6 link-local
+	LinkLocalPorts []int
+	// Ports probed on the host and other guests over AF_VSOCK
+	VsockPorts  []int
+	InternalMap bool
+	SpoofMatrix bool
+	Reflector   string
+	// Shared secret authenticating requests to the reflector
+	ReflectorSecret string
+	DryRun          bool
+	// Reflector options
+	Listen string
+	// Prefixes the reflector may connect to besides the requesting address
+	AllowTargets []netip.Prefix
 	// Global options
-	OutputFormat string
-	OutputFile   string
+	OutputFormat   string
+	OutputFile     string
+	AllowIntrusive Impact
+	ScopeFile      string
 }
 
 var config HostileConfig
 
+const defaultLinkLocalPorts = "22,80,443,3128,5900,8006,8080,8443,9090,16509"
+
 func parseArgs() {
 	if len(os.Args) < 2 {
 		printUsage()
@@ -37,7 +57,9 @@ func parseArgs() {
 		detectCmd := flag.NewFlagSet("detect", flag.ExitOnError)
 		detectCmd.String("output-format", "text", "Output format (html, text, json)")
 		detectCmd.String("output-file", "hostile-report", "Name of the output report file")
+		addSafetyFlags(detectCmd)
 		detectCmd.Parse(os.Args[2:])
+		parseSafetyFlags(detectCmd)
 		config.OutputFormat = getStringFlag(detectCmd, "output-format")
 		config.OutputFile = getStringFlag(detectCmd, "output-file")
 
@@ -46,7 +68,9 @@ func parseArgs() {
 		scanCmd.String("tech", "all", "Technology to scan (lxc, proxmox, xen, hyperv, vmware, all)")
 		scanCmd.String("output-format", "text", "Output format (html, text, json)")
 		scanCmd.String("output-file", "hostile-report", "Name of the output report file")
+		addSafetyFlags(scanCmd)
 		scanCmd.Parse(os.Args[2:])
+		parseSafetyFlags(scanCmd)
 		config.Tech = getStringFlag(scanCmd, "tech")
 		config.OutputFormat = getStringFlag(scanCmd, "output-format")
 		config.OutputFile = getStringFlag(scanCmd, "output-file")
@@ -54,21 +78,40 @@ func parseArgs() {
 	case "network":
 		networkCmd := flag.NewFlagSet("network", flag.ExitOnError)
 		networkCmd.Bool("spoof", false, "Enable spoofing")
-		networkCmd.String("interface", "", "Interface to use for spoofing")
+		networkCmd.String("interface", "", "Comma-separated interfaces to test (default: all interfaces that are up)")
 		networkCmd.Bool("ipv4", false, "Spoof IPv4")
 		networkCmd.Bool("ipv6", false, "Spoof IPv6")
 		networkCmd.String("ip", "", "Set this IP when spoofing")
+		networkCmd.String("ll-ports", defaultLinkLocalPorts, "TCP ports to probe on neighbours via IPv6 link-local")
+		networkCmd.String("vsock-ports", defaultVsockPorts, "vsock ports to probe on the host and neighbouring CIDs")
+		networkCmd.Bool("internal-map", false, "Map which private and provider-internal ranges are routable")
+		networkCmd.Bool("spoof-matrix", false, "Test several classes of spoofed source addresses (all of them with -reflector)")
+		networkCmd.String("reflector", "", "Reflector (host:port) used to verify inbound traffic to the spoofed IP")
+		networkCmd.String("reflector-secret", "", "Shared secret configured on the reflector with -secret")
 		networkCmd.String("output-format", "text", "Output format (html, text, json)")
 		networkCmd.String("output-file", "hostile-report", "Name of the output report file")
+		addSafetyFlags(networkCmd)
 		networkCmd.Parse(os.Args[2:])
+		parseSafetyFlags(networkCmd)
 		config.Spoof = getBoolFlag(networkCmd, "spoof")
 		config.Interface = getStringFlag(networkCmd, "interface")
 		config.Ipv4 = getBoolFlag(networkCmd, "ipv4")
 		config.Ipv6 = getBoolFlag(networkCmd, "ipv6")
 		config.IP = getStringFlag(networkCmd, "ip")
+		config.LinkLocalPorts = getPortsFlag(networkCmd, "ll-ports")
+		config.VsockPorts = getPortsFlag(networkCmd, "vsock-ports")
+		config.InternalMap = getBoolFlag(networkCmd, "internal-map")
+		config.SpoofMatrix = getBoolFlag(networkCmd, "spoof-matrix")
+		config.Reflector = getStringFlag(networkCmd, "reflector")
+		config.ReflectorSecret = getStringFlag(networkCmd, "reflector-secret")
 		config.OutputFormat = getStringFlag(networkCmd, "output-format")
 		config.OutputFile = getStringFlag(networkCmd, "output-file")
 
+		if config.Reflector != "" && config.ReflectorSecret == "" {
+			fmt.Println("Error: -reflector requires -reflector-secret")
+			os.Exit(1)
+		}
+
 		// Auto-detect IP version if -ip is provided
 		if config.IP != "" {
 			ip := net.ParseIP(config.IP)
@@ -87,13 +130,41 @@ func parseArgs() {
 			}
 		}
 
+	case "reflector":
+		reflectorCmd := flag.NewFlagSet("reflector", flag.ExitOnError)
+		reflectorCmd.String("listen", ":7070", "Address to listen on for reflection requests")
+		reflectorCmd.String("secret", "", "Shared secret clients must authenticate with (required)")
+		reflectorCmd.String("allow-targets", "", "Comma-separated CIDRs the reflector may connect to besides the requesting address")
+		reflectorCmd.Parse(os.Args[2:])
+		config.Listen = getStringFlag(reflectorCmd, "listen")
+		config.ReflectorSecret = getStringFlag(reflectorCmd, "secret")
+		if config.ReflectorSecret == "" {
+			fmt.Println("Error: the reflector requires -secret")
+			os.Exit(1)
+		}
+		for _, cidr := range strings.Split(getStringFlag(reflectorCmd, "allow-targets"), ",") {
+			if cidr = strings.TrimSpace(cidr); cidr == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				fmt.Printf("Error: invalid -allow-targets prefix: %s\n", cidr)
+				os.Exit(1)
+			}
+			config.AllowTargets = append(config.AllowTargets, prefix.Masked())
+		}
+
 	case "all":
 		allCmd := flag.NewFlagSet("all", flag.ExitOnError)
 		allCmd.String("output-format", "text", "Output format (html, text, json)")
 		allCmd.String("output-file", "hostile-report", "Name of the output report file")
+		addSafetyFlags(allCmd)
 		allCmd.Parse(os.Args[2:])
+		parseSafetyFlags(allCmd)
 		config.OutputFormat = getStringFlag(allCmd, "output-format")
 		config.OutputFile = getStringFlag(allCmd, "output-file")
+		config.LinkLocalPorts, _ = parsePorts(defaultLinkLocalPorts)
+		config.VsockPorts, _ = parsePorts(defaultVsockPorts)
 
 	default:
 		fmt.Printf("Unknown command: %s\n", config.Mode)
@@ -110,6 +181,39 @@ func getBoolFlag(fs *flag.FlagSet, name string) bool {
 	return fs.Lookup(name).Value.String() == "true"
 }
 
+func addSafetyFlags(fs *flag.FlagSet) {
+	fs.String("allow-intrusive", ImpactActiveLocal.String(), "Most intrusive check level to run (passive, active-local, active-network, disruptive)")
+	fs.String("scope", "", "File with the target CIDRs active checks may touch")
+	fs.Bool("dry-run", false, "Only discover and print the planned changes and packets")
+}
+
+func parseSafetyFlags(fs *flag.FlagSet) {
+	impact, err := parseImpact(getStringFlag(fs, "allow-intrusive"))
+	if err != nil {
+		fmt.Printf("Error: -allow-intrusive: %s\n", err.Error())
+		os.Exit(1)
+	}
+	config.AllowIntrusive = impact
+	config.DryRun = getBoolFlag(fs, "dry-run")
+
+	config.ScopeFile = getStringFlag(fs, "scope")
+	if config.ScopeFile != "" {
+		if err := loadScope(config.ScopeFile); err != nil {
+			fmt.Printf("Error: -scope: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+}
+
+func getPortsFlag(fs *flag.FlagSet, name string) []int {
+	ports, err := parsePorts(fs.Lookup(name).Value.String())
+	if err != nil {
+		fmt.Printf("Error: -%s: %s\n", name, err.Error())
+		os.Exit(1)
+	}
+	return ports
+}
+
 func printUsage() {
 	fmt.Println("Usage: hostile <command> [options]")
 	fmt.Println("\nCommands:")
@@ -117,21 +221,46 @@ func printUsage() {
 	fmt.Println("  scan                 Perform security hardening checks")
 	fmt.Println("  network              Network spoofing operations")
 	fmt.Println("  all                  Run all operations")
+	fmt.Println("  reflector            Run the reflector used to verify inbound spoofing (on a host outside the provider)")
 	fmt.Println("\nGlobal Options:")
 	fmt.Println("  -output-format       Output format (html, text, json) [default: text]")
 	fmt.Println("  -output-file         Name of output report file [default: hostile-report]")
+	fmt.Println("  -allow-intrusive     Most intrusive check level to run [default: active-local]")
+	fmt.Println("                         passive         read files and kernel state only")
+	fmt.Println("                         active-local    change state or open devices inside this host")
+	fmt.Println("                         active-network  send packets to other hosts (scans, probes)")
+	fmt.Println("                         disruptive      take over addresses, may break connectivity")
+	fmt.Println("  -scope               File with the target CIDRs active checks may touch, one per line")
+	fmt.Println("  -dry-run             Only discover and print the planned changes and packets (saved to the report file)")
 	fmt.Println("\nScan Options:")
 	fmt.Println("  -tech                Technology to scan (lxc, proxmox, xen, hyperv, vmware, all) [default: all]")
 	fmt.Println("\nNetwork Options:")
 	fmt.Println("  -spoof               Enable spoofing")
-	fmt.Println("  -interface           Interface to use for spoofing")
+	fmt.Println("  -interface           Comma-separated interfaces to test [default: all interfaces that are up]")
 	fmt.Println("  -ipv4                Spoof IPv4 (auto-detected if -ip is provided)")
 	fmt.Println("  -ipv6                Spoof IPv6 (auto-detected if -ip is provided)")
 	fmt.Println("  -ip                  Set this IP when spoofing (auto-detects IPv4/IPv6)")
+	fmt.Println("  -ll-ports            TCP ports to probe on neighbours via IPv6 link-local [default: " + defaultLinkLocalPorts + "]")
+	fmt.Println("  -vsock-ports         vsock ports to probe on the host (CID 2) and neighbouring CIDs [default: " + defaultVsockPorts + "]")
+	fmt.Println("  -internal-map        Map which private and provider-internal ranges are routable")
+	fmt.Println("  -spoof-matrix        Test several classes of spoofed source addresses (all of them with -reflector)")
+	fmt.Println("  -reflector           Reflector (host:port) used to verify inbound traffic to the spoofed IP")
+	fmt.Println("  -reflector-secret    Shared secret configured on the reflector with -secret")
+	fmt.Println("\nReflector Options:")
+	fmt.Println("  -listen              Address to listen on for reflection requests [default: :7070]")
+	fmt.Println("  -secret              Shared secret clients must authenticate with (required)")
+	fmt.Println("  -allow-targets       Comma-separated CIDRs the reflector may connect to besides the requesting address")
 	fmt.Println("\nExamples:")
 	fmt.Println("  hostile detect")
 	fmt.Println("  hostile scan -tech lxc -output-format json")
-	fmt.Println("  hostile network -spoof -ip 1.2.3.4 -interface eth0")
-	fmt.Println("  hostile network -spoof -ipv6 -interface eth0")
+	fmt.Println("  hostile network -allow-intrusive active-network -scope scope.txt")
+	fmt.Println("  hostile network -spoof -ip 1.2.3.4 -interface eth0 -allow-intrusive disruptive")
+	fmt.Println("  hostile network -spoof -ipv6 -interface eth0 -allow-intrusive disruptive")
+	fmt.Println("  hostile network -internal-map -allow-intrusive active-network")
+	fmt.Println("  hostile network -spoof-matrix -ipv4 -allow-intrusive disruptive")
+	fmt.Println("  hostile network -dry-run -output-format html")
+	fmt.Println("  hostile reflector -listen :7070 -secret s3cret -allow-targets 203.0.113.0/24")
+	fmt.Println("  hostile network -spoof -ipv4 -reflector 198.51.100.7:7070 -reflector-secret s3cret -allow-intrusive disruptive")
+	fmt.Println("  hostile all -dry-run -allow-intrusive disruptive")
 	fmt.Println("  hostile all -output-file my-report")
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Provisioning drive labels and the platform that attaches them
+var configDrives = []struct {
+	Label    string
+	Platform string
+}{
+	{"config-2", "OpenStack"},
+	{"CONFIG-2", "OpenStack"},
+	{"cidata", "NoCloud"},
+	{"CIDATA", "NoCloud"},
+	{"CONTEXT", "OpenNebula"},
+}
+
+// OpenNebula context.sh variables holding credentials
+var contextSecretVars = map[string]bool{
+	"PASSWORD":              true,
+	"PASSWORD_BASE64":       true,
+	"ROOT_PASSWORD":         true,
+	"CRYPTED_PASSWORD":      true,
+	"CRYPTED_ROOT_PASSWORD": true,
+	"ONEGATE_TOKEN":         true,
+	"START_SCRIPT":          true,
+	"START_SCRIPT_BASE64":   true,
+}
+
+// mountConfigDrive returns where the drive can be read, mounting it read-only on a temporary
+// directory when it isn't mounted yet. The returned function undoes our mount. With -dry-run
+// the mount is only planned and the returned path is empty.
+func mountConfigDrive(device string) (string, func(), error) {
+	if mount := mountPointOf(device); mount != "" {
+		return mount, func() {}, nil
+	}
+	if dryRunSkip("mount", device, "mount read-only (iso9660/vfat) on a temporary directory") {
+		return "", func() {}, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "hostile-configdrive-")
+	if err != nil {
+		return "", nil, err
+	}
+	for _, fstype := range []string{"iso9660", "vfat"} {
+		if err = unix.Mount(resolved, dir, fstype, unix.MS_RDONLY|unix.MS_NOEXEC|unix.MS_NOSUID|unix.MS_NODEV, ""); err == nil {
+			return dir, func() {
+				unix.Unmount(dir, 0)
+				os.Remove(dir)
+			}, nil
+		}
+	}
+	os.Remove(dir)
+	return "", nil, fmt.Errorf("failed to mount %s read-only: %w", resolved, err)
+}
+
+// reportJSONSecrets walks decoded JSON and reports password-like keys
+func reportJSONSecrets(path, key string, value any) int {
+	findings := 0
+	switch v := value.(type) {
+	case map[string]any:
+		for k, child := range v {
+			findings += reportJSONSecrets(path, k, child)
+		}
+	case []any:
+		for _, child := range v {
+			findings += reportJSONSecrets(path, key, child)
+		}
+	case string:
+		lower := strings.ToLower(key)
+		if v != "" && (strings.Contains(lower, "pass") || strings.Contains(lower, "secret") || strings.Contains(lower, "token")) {
+			log.Printf("[ConfigDrive][Secrets] WARNING: %s: %s: %s", path, key, redactSecret(v))
+			findings++
+		}
+	}
+	return findings
+}
+
+// parseMetaDataJSON reports the admin password, metadata secrets and injected keys in an OpenStack meta_data.json
+func parseMetaDataJSON(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	var meta map[string]any
+	if err := json.Unmarshal(data, &meta); err != nil {
+		log.Printf("[ConfigDrive] Failed to parse %s: %v", path, err)
+		return 0
+	}
+
+	if keys, ok := meta["public_keys"].(map[string]any); ok && len(keys) > 0 {
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		log.Printf("[ConfigDrive][Keys] %s: SSH keys injected: %s", path, strings.Join(names, ", "))
+	}
+	return reportJSONSecrets(path, "", meta)
+}
+
+// parseNetworkDataJSON summarises the networks handed to the instance, which reveal internal addressing
+func parseNetworkDataJSON(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var network struct {
+		Networks []struct {
+			ID        string `json:"id"`
+			Type      string `json:"type"`
+			IPAddress string `json:"ip_address"`
+			Netmask   string `json:"netmask"`
+			Routes    []struct {
+				Network string `json:"network"`
+				Gateway string `json:"gateway"`
+			} `json:"routes"`
+		} `json:"networks"`
+		Services []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"services"`
+	}
+	if err := json.Unmarshal(data, &network); err != nil {
+		log.Printf("[ConfigDrive] Failed to parse %s: %v", path, err)
+		return
+	}
+	for _, n := range network.Networks {
+		var routes []string
+		for _, r := range n.Routes {
+			routes = append(routes, r.Network+" via "+r.Gateway)
+		}
+		log.Printf("[ConfigDrive][Network] %s: network %s (%s) %s/%s routes: %s",
+			path, n.ID, n.Type, n.IPAddress, n.Netmask, strings.Join(routes, ", "))
+	}
+	for _, s := range network.Services {
+		log.Printf("[ConfigDrive][Network] %s: service %s at %s", path, s.Type, s.Address)
+	}
+}
+
+// parseContextSh reports credentials set in an OpenNebula context.sh
+func parseContextSh(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	findings := 0
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(strings.TrimPrefix(name, "export "))
+		value = strings.Trim(strings.TrimSpace(value), `'"`)
+		if contextSecretVars[name] && value != "" {
+			log.Printf("[ConfigDrive][Secrets] WARNING: %s:%d %s=%s", path, lineNo, name, redactSecret(value))
+			findings++
+		}
+	}
+	return findings
+}
+
+// isCloudConfig reports whether user-data is a #cloud-config document rather than a script or archive
+func isCloudConfig(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	line, _ := bufio.NewReader(file).ReadString('\n')
+	return strings.HasPrefix(line, "#cloud-config")
+}
+
+// inspectConfigDrive parses every file on a mounted drive with the parser for its kind. Each file
+// goes through a single scanner so a secret is only counted once.
+func inspectConfigDrive(root string) int {
+	findings := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		switch name := strings.ToLower(d.Name()); {
+		case name == "meta_data.json":
+			findings += parseMetaDataJSON(path)
+		case name == "network_data.json":
+			parseNetworkDataJSON(path)
+		case name == "context.sh":
+			findings += parseContextSh(path)
+		case (name == "user_data" || name == "user-data") && isCloudConfig(path):
+			findings += scanCloudConfig(path)
+		default:
+			findings += scanFileForSecrets(path)
+		}
+		return nil
+	})
+	return findings
+}
+
+// bootTime returns the system boot time from /proc/stat
+func bootTime() (time.Time, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("btime not found in /proc/stat")
+}
+
+// cloudInitFirstBoot returns when cloud-init provisioned this instance, from its once-per-instance semaphores
+func cloudInitFirstBoot() (time.Time, bool) {
+	var first time.Time
+	files, _ := filepath.Glob("/var/lib/cloud/instance/sem/config_*")
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && (first.IsZero() || info.ModTime().Before(first)) {
+			first = info.ModTime()
+		}
+	}
+	return first, !first.IsZero()
+}
+
+// CheckConfigDrives inspects config-2, cidata and OpenNebula CONTEXT drives for credentials. The
+// drive is only needed while provisioning, so one that is still attached is reported as well.
+func CheckConfigDrives() bool {
+	passed := true
+	found := false
+	seen := make(map[string]bool)
+	for _, drive := range configDrives {
+		device := "/dev/disk/by-label/" + drive.Label
+		resolved, err := filepath.EvalSymlinks(device)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+		found = true
+		log.Printf("[ConfigDrive] Found %s drive %s", drive.Platform, device)
+
+		log.Printf("[ConfigDrive][Persistence] WARNING: %s drive %s is still attached after provisioning", drive.Platform, device)
+		passed = false
+		if firstBoot, ok := cloudInitFirstBoot(); ok {
+			if booted, err := bootTime(); err == nil && booted.After(firstBoot.Add(time.Minute)) {
+				log.Printf("[ConfigDrive][Persistence] %s survived a reboot (provisioned %s, booted %s)",
+					device, firstBoot.Format(time.RFC3339), booted.Format(time.RFC3339))
+			}
+		}
+
+		root, cleanup, err := mountConfigDrive(device)
+		if err != nil {
+			log.Printf("[ConfigDrive] Could not read %s: %v", device, err)
+			continue
+		}
+		if root == "" {
+			continue
+		}
+		findings := inspectConfigDrive(root)
+		cleanup()
+
+		if findings > 0 {
+			log.Printf("[ConfigDrive][Secrets] %d credentials readable on %s - anyone with root (or disk read access) can read them for as long as it stays attached", findings, device)
+			passed = false
+		}
+	}
+
+	if !found {
+		log.Println("[ConfigDrive] No config drive attached")
+	}
+	return passed
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	cpuidHypervisorBase  = 0x40000000
+	cpuidHypervisorLimit = 0x40010000
+)
+
+// Vendor signatures returned in EBX, ECX and EDX of the hypervisor leaves
+var hypervisorSignatures = map[string]struct {
+	Name    string
+	Product string
+}{
+	"KVMKVMKVM":    {"kvm", "KVM"},
+	"Linux KVM Hv": {"kvm", "KVM (Hyper-V enlightenments)"},
+	"TCGTCGTCGTCG": {"kvm", "QEMU TCG (emulation)"},
+	"XenVMMXenVMM": {"xen", "Xen"},
+	"Microsoft Hv": {"hyperv", "Microsoft Hyper-V"},
+	"VMwareVMware": {"vmware", "VMware"},
+	"bhyve bhyve":  {"bhyve", "bhyve"},
+	"VBoxVBoxVBox": {"virtualbox", "VirtualBox"},
+	"prl hyperv":   {"parallels", "Parallels"},
+	"lrpepyh  vr":  {"parallels", "Parallels"},
+	"ACRNACRNACRN": {"acrn", "ACRN"},
+	"QNXQVMBSQG":   {"qnx", "QNX hypervisor"},
+	"EVMMEVMMEVMM": {"evmm", "Intel EVMM"},
+}
+
+// hypervisorLeaf is one hypervisor CPUID range. Hypervisors emulating Hyper-V (KVM, Xen
+// viridian) answer as Microsoft Hv at the base and with their own signature 0x100 higher.
+type hypervisorLeaf struct {
+	Base      uint32
+	MaxLeaf   uint32
+	Signature string
+}
+
+func cpuidString(regs ...uint32) string {
+	buf := make([]byte, 4*len(regs))
+	for i, reg := range regs {
+		binary.LittleEndian.PutUint32(buf[4*i:], reg)
+	}
+	return strings.TrimSpace(strings.TrimRight(string(buf), "\x00"))
+}
+
+// cpuidHypervisorPresent reads the hypervisor-present bit, CPUID.1:ECX[31]
+func cpuidHypervisorPresent() bool {
+	if !cpuidAvailable {
+		return false
+	}
+	_, _, ecx, _ := cpuid(1, 0)
+	return ecx&(1<<31) != 0
+}
+
+// cpuidHypervisorLeaves scans 0x40000000-0x4000ff00 for hypervisor signatures
+func cpuidHypervisorLeaves() []hypervisorLeaf {
+	if !cpuidHypervisorPresent() {
+		return nil
+	}
+	var leaves []hypervisorLeaf
+	for base := uint32(cpuidHypervisorBase); base < cpuidHypervisorLimit; base += 0x100 {
+		eax, ebx, ecx, edx := cpuid(base, 0)
+		signature := cpuidString(ebx, ecx, edx)
+		if eax < base || eax > base+0xff || signature == "" || len(printableStrings([]byte(signature), len(signature))) == 0 {
+			continue
+		}
+		leaves = append(leaves, hypervisorLeaf{base, eax, signature})
+	}
+	return leaves
+}
+
+var (
+	cpuidOnce      sync.Once
+	cpuidHVName    string
+	cpuidHVProduct string
+	cpuidHVLeaf    hypervisorLeaf
+)
+
+// cpuidHypervisor identifies the hypervisor from its CPUID signature. A Hyper-V signature only
+// wins when no other hypervisor answers above it.
+func cpuidHypervisor() string {
+	cpuidOnce.Do(func() {
+		for _, leaf := range cpuidHypervisorLeaves() {
+			known, ok := hypervisorSignatures[leaf.Signature]
+			if !ok {
+				log.Printf("[VM][CPUID] Unknown hypervisor signature %q at %#x", leaf.Signature, leaf.Base)
+				continue
+			}
+			log.Printf("[VM][CPUID] Hypervisor signature %q at %#x (%s)", leaf.Signature, leaf.Base, known.Product)
+			if cpuidHVName == "" || cpuidHVName == "hyperv" {
+				cpuidHVName, cpuidHVProduct, cpuidHVLeaf = known.Name, known.Product, leaf
+			}
+		}
+		if cpuidHVName == "" && cpuidHypervisorPresent() {
+			log.Println("[VM][CPUID] Hypervisor-present bit set without a known signature")
+		}
+	})
+	return cpuidHVName
+}
+
+var kvmFeatures = []string{
+	0: "clocksource", 1: "nop-io-delay", 2: "mmu-op", 3: "clocksource2", 4: "async-pf", 5: "steal-time",
+	6: "pv-eoi", 7: "pv-unhalt", 9: "pv-tlb-flush", 10: "async-pf-vmexit", 11: "pv-send-ipi",
+	12: "poll-control", 13: "pv-sched-yield", 14: "async-pf-int", 15: "msi-ext-dest-id",
+	16: "hc-map-gpa-range", 17: "migration-control", 24: "clocksource-stable",
+}
+
+func bitNames(value uint32, names []string) []string {
+	var set []string
+	for bit, name := range names {
+		if name != "" && value&(1<<bit) != 0 {
+			set = append(set, name)
+		}
+	}
+	return set
+}
+
+// reportParavirtLeaves decodes the feature leaves following a hypervisor's signature
+func reportParavirtLeaves() {
+	leaf := cpuidHVLeaf
+	if leaf.MaxLeaf <= leaf.Base {
+		return
+	}
+	eax, _, _, edx := cpuid(leaf.Base+1, 0)
+
+	switch leaf.Signature {
+	case "KVMKVMKVM":
+		log.Printf("[VM][CPUID] KVM paravirt features: %s", strings.Join(bitNames(eax, kvmFeatures), ", "))
+		if edx&1 != 0 {
+			log.Println("[VM][CPUID] KVM hint: dedicated physical CPUs (realtime)")
+		}
+
+	case "XenVMMXenVMM":
+		log.Printf("[VM][CPUID] Xen version %d.%d", eax>>16, eax&0xffff)
+		if leaf.MaxLeaf >= leaf.Base+4 {
+			features, _, domid, _ := cpuid(leaf.Base+4, 0)
+			log.Printf("[VM][CPUID] Xen HVM features %#x", features)
+			if features&(1<<4) != 0 {
+				log.Printf("[VM][CPUID] Xen domain ID %d", domid&0xffff)
+			}
+		}
+
+	case "Microsoft Hv":
+		log.Printf("[VM][CPUID] Hyper-V interface %q", cpuidString(eax))
+		if leaf.MaxLeaf >= leaf.Base+3 {
+			build, version, sp, branch := cpuid(leaf.Base+2, 0)
+			if build != 0 {
+				log.Printf("[VM][CPUID] WARNING: Hyper-V host build %d.%d.%d, service pack %d, branch %d.%d", version>>16, version&0xffff, build, sp, branch>>24, branch&0xffffff)
+			}
+			_, privileges, _, _ := cpuid(leaf.Base+3, 0)
+			if privileges&1 != 0 {
+				log.Println("[VM][CPUID] WARNING: Hyper-V CreatePartitions privilege, this is the root partition")
+			}
+		}
+
+	case "VMwareVMware":
+		if leaf.MaxLeaf >= leaf.Base+0x10 {
+			tsc, bus, _, _ := cpuid(leaf.Base+0x10, 0)
+			log.Printf("[VM][CPUID] VMware TSC %d kHz, bus %d kHz", tsc, bus)
+		}
+	}
+}
+
+// Model names of the CPU models hypervisors define, anything else is the host's own brand string
+var genericCPUModels = []string{
+	"QEMU Virtual CPU", "Common KVM processor", "Common 32-bit KVM processor", "Intel Core Processor (",
+	"Intel Xeon Processor (", "Intel Atom Processor (", "AMD EPYC Processor", "AMD EPYC-", "AMD Opteron 2",
+	"Intel Core i7 9xx", "Intel Core 2 Duo P9xxx", "Westmere E56xx", "Hygon Dhyana Processor",
+	// Firecracker's normalized Intel brand string
+	"Intel(R) Xeon(R) Processor",
+}
+
+func cpuBrand() string {
+	if max, _, _, _ := cpuid(0x80000000, 0); max < 0x80000004 {
+		return ""
+	}
+	var regs []uint32
+	for leaf := uint32(0x80000002); leaf <= 0x80000004; leaf++ {
+		eax, ebx, ecx, edx := cpuid(leaf, 0)
+		regs = append(regs, eax, ebx, ecx, edx)
+	}
+	return cpuidString(regs...)
+}
+
+// CheckCPUID reports the paravirtual interfaces, the CPU model the host passes through and
+// sensitive features exposed to this guest
+func CheckCPUID() bool {
+	if !cpuidAvailable {
+		log.Printf("[VM][CPUID] CPUID is not available on %s", runtime.GOARCH)
+		return true
+	}
+	passed := true
+
+	if cpuidHypervisor() != "" {
+		log.Printf("[VM][CPUID] %s, max leaf %#x", cpuidHVProduct, cpuidHVLeaf.MaxLeaf)
+		reportParavirtLeaves()
+	} else {
+		log.Println("[VM][CPUID] No known hypervisor signature")
+	}
+
+	maxLeaf, vb, vc, vd := cpuid(0, 0)
+	vendor := cpuidString(vb, vd, vc)
+	signature, _, features, _ := cpuid(1, 0)
+	family := (signature >> 8) & 0xf
+	model := (signature >> 4) & 0xf
+	if family == 0xf {
+		family += (signature >> 20) & 0xff
+	}
+	if family == 0x6 || family >= 0xf {
+		model |= (signature >> 12) & 0xf0
+	}
+	brand := cpuBrand()
+	log.Printf("[VM][CPUID] %s family %#x model %#x stepping %d: %s", vendor, family, model, signature&0xf, brand)
+
+	generic := false
+	for _, prefix := range genericCPUModels {
+		if strings.HasPrefix(brand, prefix) {
+			generic = true
+			break
+		}
+	}
+	if !generic && brand != "" {
+		log.Printf("[VM][CPUID] WARNING: host CPU model passed through (%s), reveals the host hardware generation and its side channel exposure", brand)
+		passed = false
+	}
+
+	var extFeatures uint32
+	if max, _, _, _ := cpuid(0x80000000, 0); max >= 0x80000001 {
+		_, _, extFeatures, _ = cpuid(0x80000001, 0)
+	}
+	var leaf7 uint32
+	if maxLeaf >= 7 {
+		_, leaf7, _, _ = cpuid(7, 0)
+	}
+	var pmuVersion uint32
+	if maxLeaf >= 0xa {
+		eax, _, _, _ := cpuid(0xa, 0)
+		pmuVersion = eax & 0xff
+	}
+
+	sensitive := []struct {
+		Present bool
+		Message string
+	}{
+		{features&(1<<5) != 0, "vmx exposed, nested virtualization gives the guest the host's nested VMX emulation as attack surface"},
+		{extFeatures&(1<<2) != 0, "svm exposed, nested virtualization gives the guest the host's nested SVM emulation as attack surface"},
+		{leaf7&(1<<2) != 0, "sgx exposed, enclaves are backed by the host's EPC"},
+		{features&(1<<3) != 0, "monitor/mwait exposed, the guest can idle physical CPUs without exiting"},
+		{pmuVersion != 0, fmt.Sprintf("architectural PMU version %d exposed, performance counters enable cross-guest side channels", pmuVersion)},
+	}
+	for _, feature := range sensitive {
+		if feature.Present {
+			log.Printf("[VM][CPUID] WARNING: %s", feature.Message)
+			passed = false
+		}
+	}
+	return passed
+}
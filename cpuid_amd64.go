@@ -0,0 +1,8 @@
+//go:build amd64
+
+package main
+
+const cpuidAvailable = true
+
+// Implemented in cpuid_amd64.s
+func cpuid(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32)
@@ -0,0 +1,10 @@
+//go:build !amd64
+
+package main
+
+// CPUID is an x86 instruction, other architectures fall back to the remaining detectors
+const cpuidAvailable = false
+
+func cpuid(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32) {
+	return 0, 0, 0, 0
+}
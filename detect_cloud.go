@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var dmiFields = []string{
+	"sys_vendor",
+	"product_name",
+	"product_version",
+	"bios_vendor",
+	"bios_version",
+	"board_vendor",
+	"board_asset_tag",
+	"chassis_vendor",
+	"chassis_asset_tag",
+}
+
+// platformEvidence collects and logs the signals found for one platform. DMI strings, hypervisor
+// identifiers and metadata API answers identify a platform on their own. MAC prefixes, files and
+// configuration mentions are easy to come by elsewhere, so they need a second, different kind of
+// signal to back them up.
+type platformEvidence struct {
+	Name   string
+	Found  []string
+	Strong bool
+	Kinds  map[string]bool
+}
+
+func newEvidence(name string) *platformEvidence {
+	return &platformEvidence{Name: name, Kinds: make(map[string]bool)}
+}
+
+func (e *platformEvidence) add(format string, args ...any) {
+	evidence := fmt.Sprintf(format, args...)
+	e.Found = append(e.Found, evidence)
+	log.Printf("[Platform][%s] %s", e.Name, evidence)
+}
+
+// strong records a signal that identifies the platform by itself
+func (e *platformEvidence) strong(format string, args ...any) {
+	e.Strong = true
+	e.add(format, args...)
+}
+
+// weak records a signal of the given kind, several signals of one kind count once
+func (e *platformEvidence) weak(kind, format string, args ...any) {
+	e.Kinds[kind] = true
+	e.add(format, args...)
+}
+
+func (e *platformEvidence) detected() bool {
+	return e.Strong || len(e.Kinds) >= 2
+}
+
+// dmi looks for any of the needles (case-insensitive) in the DMI/SMBIOS strings
+func (e *platformEvidence) dmi(needles ...string) {
+	for _, field := range dmiFields {
+		data, err := os.ReadFile("/sys/class/dmi/id/" + field)
+		if err != nil {
+			continue
+		}
+		value := strings.TrimSpace(string(data))
+		for _, needle := range needles {
+			if strings.Contains(strings.ToLower(value), strings.ToLower(needle)) {
+				e.strong("DMI %s: %s", field, value)
+				break
+			}
+		}
+	}
+}
+
+// mac looks for interfaces whose MAC address starts with one of the provider's OUIs
+func (e *platformEvidence) mac(prefixes ...string) {
+	entries, err := os.ReadDir("/sys/class/net")
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile("/sys/class/net/" + entry.Name() + "/address")
+		if err != nil {
+			continue
+		}
+		mac := strings.ToLower(strings.TrimSpace(string(data)))
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(mac, strings.ToLower(prefix)) {
+				e.weak("mac", "MAC prefix %s on %s", prefix, entry.Name())
+				break
+			}
+		}
+	}
+}
+
+// file looks for agents, tools and provisioning leftovers
+func (e *platformEvidence) file(paths ...string) {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			e.weak("file", "Found %s", path)
+		}
+	}
+}
+
+// label looks for provisioning drives by filesystem label
+func (e *platformEvidence) label(labels ...string) {
+	for _, label := range labels {
+		if _, err := os.Stat("/dev/disk/by-label/" + label); err == nil {
+			e.weak("label", "Drive labelled %s attached", label)
+		}
+	}
+}
+
+// contains looks for a signature in configuration files
+func (e *platformEvidence) contains(needle string, files ...string) {
+	for _, file := range files {
+		if data, err := os.ReadFile(file); err == nil && strings.Contains(strings.ToLower(string(data)), strings.ToLower(needle)) {
+			e.weak("config", "Found %q in %s", needle, file)
+		}
+	}
+}
+
+// metadata checks whether the metadata service answers the provider's API
+func (e *platformEvidence) metadata(provider string) {
+	for _, p := range metadataProviders {
+		if p.Name == provider && probeMetadataProvider(p) {
+			e.strong("Metadata API answers at %s%s", metadataIPv4URL, p.Probe)
+		}
+	}
+}
+
+var (
+	metadataProbeOnce      sync.Once
+	metadataProbeReachable bool
+	metadataProbeMu        sync.Mutex
+	metadataProbeCache     = make(map[string]bool)
+)
+
+// probeMetadataProvider reports whether the provider's metadata API answers. The service is
+// only contacted once per provider, and not at all after it turned out to be unreachable.
+func probeMetadataProvider(p metadataProvider) bool {
+	client := newMetadataClient(metadataIPv4URL, &http.Client{Timeout: 2 * time.Second})
+	metadataProbeOnce.Do(func() {
+		metadataProbeReachable = client.reachable()
+	})
+	if !metadataProbeReachable {
+		return false
+	}
+
+	metadataProbeMu.Lock()
+	defer metadataProbeMu.Unlock()
+	if present, ok := metadataProbeCache[p.Name]; ok {
+		return present
+	}
+	present, _, _ := client.detect(p)
+	metadataProbeCache[p.Name] = present
+	return present
+}
+
+func IsAWS() bool {
+	e := newEvidence("AWS")
+	e.dmi("Amazon EC2")
+	// Xen based instances expose the UUID under /sys/hypervisor, Nitro ones in DMI
+	for _, path := range []string{"/sys/hypervisor/uuid", "/sys/class/dmi/id/product_uuid"} {
+		if data, err := os.ReadFile(path); err == nil && strings.HasPrefix(strings.ToLower(string(data)), "ec2") {
+			e.strong("%s starts with ec2", path)
+		}
+	}
+	hardware := e.Strong
+	e.file("/usr/bin/amazon-ssm-agent", "/snap/amazon-ssm-agent", "/etc/amazon")
+	// OpenStack and OVH serve the same EC2 compatible API, so it only counts on EC2 hardware
+	if hardware {
+		e.metadata("EC2")
+	}
+	return e.detected()
+}
+
+func IsGCP() bool {
+	e := newEvidence("GCP")
+	e.dmi("Google")
+	e.mac("42:01:")
+	e.file("/usr/bin/google_guest_agent", "/etc/default/instance_configs.cfg")
+	e.metadata("GCE")
+	return e.detected()
+}
+
+func IsAzure() bool {
+	e := newEvidence("Azure")
+	// Every Azure VM carries this chassis asset tag
+	e.dmi("7783-7084-3265-9085-8269-3286-77")
+	e.mac("00:0d:3a", "00:22:48", "60:45:bd", "7c:1e:52")
+	e.file("/usr/sbin/waagent", "/var/lib/waagent")
+	e.metadata("Azure")
+	return e.detected()
+}
+
+func IsDigitalOcean() bool {
+	e := newEvidence("DigitalOcean")
+	e.dmi("DigitalOcean")
+	e.file("/opt/digitalocean", "/etc/digitalocean")
+	e.metadata("DigitalOcean")
+	return e.detected()
+}
+
+func IsHetzner() bool {
+	e := newEvidence("Hetzner")
+	e.dmi("Hetzner")
+	e.mac("96:00:")
+	e.metadata("Hetzner")
+	return e.detected()
+}
+
+func IsOVH() bool {
+	e := newEvidence("OVH")
+	e.dmi("OVH")
+	e.contains("ovh.net", "/etc/hostname", "/etc/hosts", "/etc/resolv.conf")
+	return e.detected()
+}
+
+func IsLinode() bool {
+	e := newEvidence("Linode")
+	e.dmi("Linode", "Akamai")
+	e.mac("f2:3c:9")
+	e.contains("linode", "/etc/hostname", "/etc/hosts", "/etc/resolv.conf")
+	e.metadata("Linode")
+	return e.detected()
+}
+
+func IsVultr() bool {
+	e := newEvidence("Vultr")
+	e.dmi("Vultr")
+	e.mac("56:00:0")
+	e.metadata("Vultr")
+	return e.detected()
+}
+
+func IsOracleCloud() bool {
+	e := newEvidence("Oracle")
+	e.dmi("OracleCloud.com")
+	e.mac("02:00:17")
+	e.file("/var/lib/oracle-cloud-agent", "/etc/oracle-cloud-agent")
+	e.metadata("Oracle")
+	return e.detected()
+}
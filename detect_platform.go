@@ -9,14 +9,33 @@ import (
 )
 
 func DetectPlatform() (bool, string) {
-	if IsProxmox() {
-		return true, "proxmox"
+	// Providers with their own signatures come before the generic platforms they build on
+	// (eg. OVH Public Cloud is OpenStack)
+	detectors := []struct {
+		Name   string
+		Detect func() bool
+	}{
+		{"aws", IsAWS},
+		{"gcp", IsGCP},
+		{"azure", IsAzure},
+		{"digitalocean", IsDigitalOcean},
+		{"hetzner", IsHetzner},
+		{"linode", IsLinode},
+		{"vultr", IsVultr},
+		{"oracle", IsOracleCloud},
+		{"ovh", IsOVH},
+		{"opennebula", IsOpenNebula},
+		{"virtuozzo", IsVirtuozzo},
+		{"xcp-ng", IsXCPNG},
+		{"proxmox", IsProxmox},
+		{"openstack", IsOpenStack},
+		{"solusvm", IsSolusVM},
+		{"virtualizor", IsVirtualizor},
 	}
-	if IsOpenStack() {
-		return true, "openstack"
-	}
-	if IsSolusVM() {
-		return true, "solusvm"
+	for _, d := range detectors {
+		if d.Detect() {
+			return true, d.Name
+		}
 	}
 	return false, ""
 }
@@ -98,3 +117,59 @@ func IsOpenStack() bool {
 
 	return false
 }
+
+func IsVirtualizor() bool {
+	e := newEvidence("Virtualizor")
+	e.dmi("Virtualizor")
+	e.contains("virtualizor",
+		"/etc/sysconfig/network",
+		"/etc/sysconfig/network-scripts/ifcfg-eth0",
+		"/etc/network/interfaces",
+		"/etc/hosts",
+		"/etc/hostname",
+	)
+	return e.detected()
+}
+
+func IsOpenNebula() bool {
+	e := newEvidence("OpenNebula")
+	e.dmi("OpenNebula")
+	e.label("CONTEXT")
+	e.file("/etc/one-context.d", "/usr/sbin/one-contextd", "/var/run/one-context")
+	return e.detected()
+}
+
+func IsVirtuozzo() bool {
+	e := newEvidence("Virtuozzo")
+	e.dmi("Virtuozzo")
+	// Containers have no DMI, only OpenVZ/Virtuozzo kernels provide these
+	for _, path := range []string{"/proc/vz", "/proc/bc"} {
+		if _, err := os.Stat(path); err == nil {
+			e.strong("Found %s", path)
+		}
+	}
+	// Parallels Desktop uses the same NIC OUI and guest tools, so they only back up a Virtuozzo signal
+	if e.detected() {
+		e.mac("00:1c:42")
+		e.file("/usr/bin/prltoolsd", "/usr/bin/prl_nettool")
+	}
+	return e.detected()
+}
+
+// IsXCPNG looks for a Xen guest with XenServer/XCP-ng guest tools or the xenstore keys XAPI manages
+func IsXCPNG() bool {
+	e := newEvidence("XCP-ng")
+	e.dmi("xcp-ng", "xenserver")
+	e.file(append(xcpngGuestAgents, "/usr/sbin/xe-linux-distribution", "/opt/xensource")...)
+
+	if xs, err := openXenstore(); err == nil {
+		defer xs.Close()
+		// vm-data is populated by XAPI, attr/PVAddons by the XenServer/XCP-ng guest agents
+		for _, path := range []string{"vm-data", "attr/PVAddons"} {
+			if _, err := xs.Directory(path); err == nil {
+				e.weak("xenstore", "xenstore key %s present", path)
+			}
+		}
+	}
+	return e.detected()
+}
@@ -7,17 +7,43 @@ import (
 )
 
 func DetectVM() (bool, string) {
-	if IsKVM() {
-		return true, "kvm"
+	// The CPUID signature comes from the hypervisor itself, the other signals are firmware and
+	// devices the provider can rename
+	hypervisor := cpuidHypervisor()
+	if hypervisor == "" {
+		switch {
+		case IsKVM():
+			hypervisor = "kvm"
+		case IsXen():
+			hypervisor = "xen"
+		case IsHyperV():
+			hypervisor = "hyperv"
+		case IsVMware():
+			hypervisor = "vmware"
+		}
+	}
+	switch hypervisor {
+	case "kvm":
+		if machine := qemuMachineType(); machine != "" {
+			log.Printf("[VM][QEMU/KVM] Machine type: %s", machine)
+		}
+	case "xen":
+		XenInventory()
 	}
-	if IsXen() {
-		return true, "xen"
+	if hypervisor != "" {
+		return true, hypervisor
 	}
-	if IsHyperV() {
-		return true, "hyperv"
+	if hv, value := acpiHypervisor(); hv != "" {
+		log.Printf("[VM] ACPI table %s", value)
+		return true, hv
 	}
-	if IsVMware() {
-		return true, "vmware"
+	if structures, err := readSMBIOS(); err == nil && smbiosVirtualMachine(structures) {
+		log.Println("[VM] SMBIOS marks this system as a virtual machine, hypervisor not identified")
+		return true, "unknown"
+	}
+	if hasWAET() {
+		log.Println("[VM] ACPI WAET table present, hypervisor not identified")
+		return true, "unknown"
 	}
 	return false, ""
 }
@@ -39,12 +65,19 @@ func IsKVM() bool {
 		}
 	}
 
-	if data, err := os.ReadFile("/proc/cpuinfo"); err == nil {
-		content := strings.ToLower(string(data))
-		if strings.Contains(content, "hypervisor") && strings.Contains(content, "qemu") {
-			log.Println("[VM][QEMU/KVM] cpuinfo contains qemu")
-			return true
-		}
+	if hv, value := acpiHypervisor(); hv == "kvm" {
+		log.Printf("[VM][QEMU/KVM] ACPI table %s", value)
+		return true
+	}
+
+	if hv, value := smbiosHypervisor(); hv == "kvm" {
+		log.Printf("[VM][QEMU/KVM] SMBIOS contains %s", value)
+		return true
+	}
+
+	if hv, dev := pciHypervisor(); hv == "kvm" {
+		log.Printf("[VM][QEMU/KVM] PCI device %s", dev)
+		return true
 	}
 
 	return false
@@ -63,6 +96,26 @@ func IsXen() bool {
 		}
 	}
 
+	if _, err := os.Stat("/dev/xen/xenbus"); err == nil {
+		log.Println("[VM][Xen] xenstore device /dev/xen/xenbus exists")
+		return true
+	}
+
+	if hv, value := acpiHypervisor(); hv == "xen" {
+		log.Printf("[VM][Xen] ACPI table %s", value)
+		return true
+	}
+
+	if hv, value := smbiosHypervisor(); hv == "xen" {
+		log.Printf("[VM][Xen] SMBIOS contains %s", value)
+		return true
+	}
+
+	if hv, dev := pciHypervisor(); hv == "xen" {
+		log.Printf("[VM][Xen] PCI device %s", dev)
+		return true
+	}
+
 	// Check for Xen devices - must have at least one device
 	if entries, err := os.ReadDir("/sys/bus/xen/devices"); err == nil {
 		if len(entries) > 0 {
@@ -92,6 +145,16 @@ func IsHyperV() bool {
 		}
 	}
 
+	if hv, value := acpiHypervisor(); hv == "hyperv" {
+		log.Printf("[VM][Hyper-V] ACPI table %s", value)
+		return true
+	}
+
+	if hv, value := smbiosHypervisor(); hv == "hyperv" {
+		log.Printf("[VM][Hyper-V] SMBIOS contains %s", value)
+		return true
+	}
+
 	if data, err := os.ReadFile("/proc/modules"); err == nil {
 		content := strings.ToLower(string(data))
 		if strings.Contains(content, "hv_vmbus") || strings.Contains(content, "hv_storvsc") || strings.Contains(content, "hyperv") {
@@ -120,9 +183,25 @@ func IsVMware() bool {
 		}
 	}
 
+	if hv, value := acpiHypervisor(); hv == "vmware" {
+		log.Printf("[VM][VMware] ACPI table %s", value)
+		return true
+	}
+
+	if hv, value := smbiosHypervisor(); hv == "vmware" {
+		log.Printf("[VM][VMware] SMBIOS contains %s", value)
+		return true
+	}
+
+	if hv, dev := pciHypervisor(); hv == "vmware" {
+		log.Printf("[VM][VMware] PCI device %s", dev)
+		return true
+	}
+
 	if data, err := os.ReadFile("/proc/modules"); err == nil {
 		content := strings.ToLower(string(data))
-		if strings.Contains(content, "vmw_") || strings.Contains(content, "vmxnet") {
+		// vmw_vsock_virtio_transport is the virtio (KVM) vsock transport despite its name
+		if strings.Contains(content, "vmw_balloon") || strings.Contains(content, "vmw_vmci") || strings.Contains(content, "vmw_pvscsi") || strings.Contains(content, "vmxnet") {
 			log.Println("[VM][VMware] VMware modules found")
 			return true
 		}
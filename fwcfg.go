@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	fwcfgSysfs   = "/sys/firmware/qemu_fw_cfg"
+	fwcfgMaxRead = 64 << 10
+)
+
+// fwcfgEntry is one fw_cfg item, named like the file directory (eg. "etc/boot-fail-wait", "opt/com.example/key")
+type fwcfgEntry struct {
+	Name string
+	Size uint32
+	Data []byte
+}
+
+// Entries that are large binary tables and never interesting as text
+var fwcfgBinaryEntries = []string{"etc/acpi/", "etc/table-loader", "genroms/", "etc/smbios/", "etc/e820", "etc/ramfb", "vgaroms/"}
+
+var hostPathRegex = regexp.MustCompile(`(/var/lib/[^\s\x00]+|/etc/pve[^\s\x00]*|/home/[^\s\x00]+|/root/[^\s\x00]+|/srv/[^\s\x00]+|/mnt/[^\s\x00]+|/opt/[^\s\x00]+|/usr/share/[^\s\x00]+|[^\s\x00/]+\.(qcow2|vmdk|img|iso|raw|fd))`)
+
+// readFwCfgSysfs reads every entry under by_name, where each item is a directory holding a raw file
+func readFwCfgSysfs() ([]fwcfgEntry, error) {
+	root := fwcfgSysfs + "/by_name"
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	var entries []fwcfgEntry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "raw" {
+			return nil
+		}
+		name, _ := filepath.Rel(root, filepath.Dir(path))
+		entry := fwcfgEntry{Name: name}
+		if info, err := d.Info(); err == nil {
+			entry.Size = uint32(info.Size())
+		}
+		if !isFwCfgBinary(name) {
+			if file, err := os.Open(path); err == nil {
+				entry.Data, _ = io.ReadAll(io.LimitReader(file, fwcfgMaxRead))
+				file.Close()
+			}
+		}
+		if entry.Size == 0 {
+			entry.Size = uint32(len(entry.Data))
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func isFwCfgBinary(name string) bool {
+	for _, prefix := range fwcfgBinaryEntries {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// printableStrings returns runs of printable ASCII of at least min bytes, like strings(1)
+func printableStrings(data []byte, min int) []string {
+	var found []string
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && data[i] >= 0x20 && data[i] < 0x7f {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= min {
+			found = append(found, string(data[start:i]))
+		}
+		start = -1
+	}
+	return found
+}
+
+// analyzeFwCfg lists the entries and reports opt/ blobs, the boot order, secrets and host paths
+func analyzeFwCfg(entries []fwcfgEntry) bool {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	passed := true
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	log.Printf("[KVM][FwCfg] %d entries: %s", len(entries), strings.Join(names, ", "))
+
+	for _, entry := range entries {
+		source := "fw_cfg:" + entry.Name
+		text := strings.Join(printableStrings(entry.Data, 4), " ")
+
+		findings := scanReaderForSecrets(source, bytes.NewReader(entry.Data))
+		for _, s := range printableStrings(entry.Data, 6) {
+			for _, hostPath := range hostPathRegex.FindAllString(s, -1) {
+				log.Printf("[KVM][FwCfg] WARNING: %s leaks host path %s", entry.Name, hostPath)
+				findings++
+			}
+		}
+		if findings > 0 {
+			passed = false
+		}
+
+		switch {
+		case strings.HasPrefix(entry.Name, "opt/") && findings > 0:
+			// Provider and management stack supplied blobs (-fw_cfg name=opt/...)
+			log.Printf("[KVM][FwCfg] WARNING: provider blob %s (%d bytes) carries credentials or host paths: %s", entry.Name, entry.Size, truncate(redactLine(text), 120))
+		case strings.HasPrefix(entry.Name, "opt/"):
+			log.Printf("[KVM][FwCfg] Provider blob %s (%d bytes)", entry.Name, entry.Size)
+		case entry.Name == "bootorder":
+			log.Printf("[KVM][FwCfg] Boot order: %s", strings.Join(strings.Fields(text), ", "))
+		case entry.Name == "cmdline" && text != "":
+			log.Printf("[KVM][FwCfg] Direct kernel boot command line: %s", truncate(redactLine(text), 120))
+		}
+	}
+	return passed
+}
+
+// redactLine applies the secret rules to a line and redacts what they match
+func redactLine(line string) string {
+	for _, rule := range secretRules {
+		for _, m := range rule.Regex.FindAllStringSubmatch(line, -1) {
+			if secret := m[rule.Group]; secret != "" && rule.Name != "private-key" {
+				line = strings.ReplaceAll(line, secret, redactSecret(secret))
+			}
+		}
+	}
+	return line
+}
+
+// CheckFwCfg lists what the host passes to the guest through QEMU's fw_cfg device, via sysfs
+// when the qemu_fw_cfg driver is loaded and the I/O ports otherwise
+func CheckFwCfg() bool {
+	entries, err := readFwCfgSysfs()
+	if err != nil {
+		log.Printf("[KVM][FwCfg] %s not available (qemu_fw_cfg driver not loaded?)", fwcfgSysfs)
+		if !allowCheck("fw-cfg-ioport", ImpactActiveLocal) || dryRunSkip("ioport", "0x510-0x511", "read the fw_cfg file directory through the I/O ports") {
+			return true
+		}
+		entries, err = readFwCfgIOPort()
+		if err != nil {
+			log.Printf("[KVM][FwCfg] I/O port interface not usable: %v", err)
+			return true
+		}
+		log.Println("[KVM][FwCfg] Read fw_cfg through the I/O port interface")
+	}
+
+	if len(entries) == 0 {
+		log.Println("[KVM][FwCfg] No fw_cfg entries")
+		return true
+	}
+	return analyzeFwCfg(entries)
+}
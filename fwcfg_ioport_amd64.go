@@ -0,0 +1,95 @@
+//go:build linux && amd64
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	fwcfgPortSelector = 0x510
+	fwcfgPortData     = 0x511
+
+	fwcfgSignature   = 0x0000
+	fwcfgCmdlineSize = 0x0014
+	fwcfgCmdlineData = 0x0015
+	fwcfgFileDir     = 0x0019
+)
+
+// Implemented in fwcfg_amd64.s
+func outw(port, value uint16)
+func inb(port uint16) uint8
+
+// fwcfgRead selects an item and reads its first n bytes
+func fwcfgRead(key uint16, n int) []byte {
+	outw(fwcfgPortSelector, key)
+	return readPortBytes(n)
+}
+
+// readFwCfgIOPort reads the fw_cfg file directory and each file through the legacy x86 I/O
+// ports. ioperm only applies to the calling thread, so the goroutine stays on it throughout.
+func readFwCfgIOPort() ([]fwcfgEntry, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := unix.Ioperm(fwcfgPortSelector, 2, 1); err != nil {
+		return nil, fmt.Errorf("ioperm: %w", err)
+	}
+	defer unix.Ioperm(fwcfgPortSelector, 2, 0)
+
+	if signature := fwcfgRead(fwcfgSignature, 4); !bytes.Equal(signature, []byte("QEMU")) {
+		return nil, fmt.Errorf("no fw_cfg signature at port %#x", fwcfgPortSelector)
+	}
+
+	var entries []fwcfgEntry
+
+	// Legacy items use little-endian, the file directory is big-endian
+	if size := binary.LittleEndian.Uint32(fwcfgRead(fwcfgCmdlineSize, 4)); size > 0 && size <= fwcfgMaxRead {
+		entries = append(entries, fwcfgEntry{Name: "cmdline", Size: size, Data: fwcfgRead(fwcfgCmdlineData, int(size))})
+	}
+
+	outw(fwcfgPortSelector, fwcfgFileDir)
+	count := binary.BigEndian.Uint32(readPortBytes(4))
+	if count > 1024 {
+		return nil, fmt.Errorf("implausible fw_cfg file count %d", count)
+	}
+
+	// Each directory entry: size (4), select key (2), reserved (2), name (56)
+	type file struct {
+		name string
+		key  uint16
+		size uint32
+	}
+	files := make([]file, 0, count)
+	for i := uint32(0); i < count; i++ {
+		raw := readPortBytes(64)
+		files = append(files, file{
+			name: string(bytes.TrimRight(raw[8:], "\x00")),
+			key:  binary.BigEndian.Uint16(raw[4:6]),
+			size: binary.BigEndian.Uint32(raw[0:4]),
+		})
+	}
+
+	for _, f := range files {
+		entry := fwcfgEntry{Name: f.name, Size: f.size}
+		if !isFwCfgBinary(f.name) && f.size <= fwcfgMaxRead {
+			entry.Data = fwcfgRead(f.key, int(f.size))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readPortBytes continues reading the currently selected item
+func readPortBytes(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = inb(fwcfgPortData)
+	}
+	return data
+}
@@ -0,0 +1,13 @@
+//go:build !(linux && amd64)
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// readFwCfgIOPort is only implemented for x86-64, other architectures expose fw_cfg through MMIO
+func readFwCfgIOPort() ([]fwcfgEntry, error) {
+	return nil, fmt.Errorf("fw_cfg I/O ports are not supported on %s", runtime.GOARCH)
+}
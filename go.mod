@@ -5,9 +5,7 @@ go 1.25.1
 require (
 	github.com/vishvananda/netlink v1.3.1
 	golang.org/x/net v0.33.0
+	golang.org/x/sys v0.28.0
 )
 
-require (
-	github.com/vishvananda/netns v0.0.5 // indirect
-	golang.org/x/sys v0.28.0 // indirect
-)
+require github.com/vishvananda/netns v0.0.5 // indirect
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Impact describes what a check touches, from least to most intrusive
+type Impact int
+
+const (
+	// ImpactPassive only reads files, kernel state and the local metadata service
+	ImpactPassive Impact = iota
+	// ImpactActiveLocal changes state or opens devices inside our own host only
+	ImpactActiveLocal
+	// ImpactActiveNetwork sends packets or requests to hosts other than our own
+	ImpactActiveNetwork
+	// ImpactDisruptive can break our connectivity or affect other tenants (eg. taking over addresses)
+	ImpactDisruptive
+)
+
+var impactNames = []string{"passive", "active-local", "active-network", "disruptive"}
+
+func (i Impact) String() string {
+	if int(i) < len(impactNames) {
+		return impactNames[i]
+	}
+	return fmt.Sprintf("impact(%d)", int(i))
+}
+
+func parseImpact(s string) (Impact, error) {
+	for i, name := range impactNames {
+		if s == name {
+			return Impact(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown impact level %q (expected %s)", s, strings.Join(impactNames, ", "))
+}
+
+// check is a named check tagged with its impact level
+type check struct {
+	Name   string
+	Impact Impact
+	Run    func()
+}
+
+func runChecks(checks []check) {
+	for _, c := range checks {
+		if allowCheck(c.Name, c.Impact) {
+			c.Run()
+		}
+	}
+}
+
+// allowCheck reports whether a check may run under -allow-intrusive. With -dry-run nothing
+// intrusive is executed, so every check runs to record its plan.
+func allowCheck(name string, impact Impact) bool {
+	if impact <= config.AllowIntrusive {
+		return true
+	}
+	if config.DryRun {
+		log.Printf("%s (%s) exceeds -allow-intrusive %s, planning only", name, impact, config.AllowIntrusive)
+		return true
+	}
+	log.Printf("Skipping %s: impact %s requires -allow-intrusive %s", name, impact, impact)
+	return false
+}
+
+var scope []netip.Prefix
+
+// loadScope reads allowed target CIDRs (or single addresses), one per line, # starts a comment
+func loadScope(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.Contains(line, "/") {
+			addr, err := netip.ParseAddr(line)
+			if err != nil {
+				return fmt.Errorf("invalid scope entry %q", line)
+			}
+			line = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		prefix, err := netip.ParsePrefix(line)
+		if err != nil {
+			return fmt.Errorf("invalid scope entry %q", line)
+		}
+		scope = append(scope, prefix.Masked())
+	}
+	return scanner.Err()
+}
+
+// inScope reports whether an active check may touch host. Without a scope file every target is
+// allowed. The host may carry an IPv6 zone (eg. "fe80::1%eth0").
+func inScope(host string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.WithZone("").Unmap()
+	for _, prefix := range scope {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
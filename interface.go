@@ -5,6 +5,7 @@ import (
 	"net"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 func GetDefaultInterface(family int) (string, error) {
@@ -33,11 +34,26 @@ func GetDefaultInterface(family int) (string, error) {
 	}
 
 	// Fallback: find first non-loopback interface with an IP of the requested family
+	interfaces, err := ListInterfaces(family)
+	if err != nil {
+		return "", err
+	}
+	if len(interfaces) > 0 {
+		return interfaces[0], nil
+	}
+
+	return "", fmt.Errorf("no suitable network interface found for family %d", family)
+}
+
+// ListInterfaces returns every interface that is up, isn't a loopback and has an address of
+// the requested family (a global one for IPv6)
+func ListInterfaces(family int) ([]string, error) {
 	links, err := netlink.LinkList()
 	if err != nil {
-		return "", fmt.Errorf("failed to list interfaces: %w", err)
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
 	}
 
+	var interfaces []string
 	for _, link := range links {
 		if link.Attrs().Flags&net.FlagLoopback != 0 {
 			continue
@@ -52,7 +68,7 @@ func GetDefaultInterface(family int) (string, error) {
 		}
 
 		// For IPv6, skip link-local only
-		if isIPv6 {
+		if family == netlink.FAMILY_V6 {
 			hasGlobal := false
 			for _, addr := range addrs {
 				if !addr.IP.IsLinkLocalUnicast() {
@@ -65,13 +81,42 @@ func GetDefaultInterface(family int) (string, error) {
 			}
 		}
 
-		return link.Attrs().Name, nil
+		interfaces = append(interfaces, link.Attrs().Name)
 	}
 
-	return "", fmt.Errorf("no suitable network interface found for family %d", family)
+	return interfaces, nil
+}
+
+func GetDefaultGateway(family int) (net.IP, string, error) {
+	routes, err := netlink.RouteList(nil, family)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get routes: %w", err)
+	}
+
+	for _, route := range routes {
+		if route.Gw == nil {
+			continue
+		}
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		link, err := netlink.LinkByIndex(route.LinkIndex)
+		if err != nil {
+			continue
+		}
+		return route.Gw, link.Attrs().Name, nil
+	}
+
+	return nil, "", fmt.Errorf("no default gateway found for family %d", family)
 }
 
 func AddIP(interfaceName string, ip net.IP, mask net.IPMask) error {
+	if dryRunSkip("netlink", interfaceName, fmt.Sprintf("add address %s", &net.IPNet{IP: ip, Mask: mask})) {
+		return nil
+	}
+
 	link, err := netlink.LinkByName(interfaceName)
 	if err != nil {
 		return err
@@ -88,6 +133,10 @@ func AddIP(interfaceName string, ip net.IP, mask net.IPMask) error {
 }
 
 func DeleteIP(interfaceName string, ip net.IP, mask net.IPMask) error {
+	if dryRunSkip("netlink", interfaceName, fmt.Sprintf("delete address %s", &net.IPNet{IP: ip, Mask: mask})) {
+		return nil
+	}
+
 	link, err := netlink.LinkByName(interfaceName)
 	if err != nil {
 		return err
@@ -102,3 +151,38 @@ func DeleteIP(interfaceName string, ip net.IP, mask net.IPMask) error {
 
 	return netlink.AddrDel(link, addr)
 }
+
+// AddHostIP adds a single address (/32 or /128) without duplicate address detection, so an
+// IPv6 address is usable immediately even when a neighbour already owns it
+func AddHostIP(interfaceName string, ip net.IP) error {
+	bits := 128
+	if ip.To4() != nil {
+		bits = 32
+	}
+	if dryRunSkip("netlink", interfaceName, fmt.Sprintf("add address %s/%d (nodad)", ip, bits)) {
+		return nil
+	}
+
+	link, err := netlink.LinkByName(interfaceName)
+	if err != nil {
+		return err
+	}
+
+	addr := &netlink.Addr{
+		IPNet: &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		},
+		Flags: unix.IFA_F_NODAD,
+	}
+
+	return netlink.AddrAdd(link, addr)
+}
+
+func DeleteHostIP(interfaceName string, ip net.IP) error {
+	bits := 128
+	if ip.To4() != nil {
+		bits = 32
+	}
+	return DeleteIP(interfaceName, ip, net.CIDRMask(bits, bits))
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+type internalRange struct {
+	CIDR    string
+	Label   string
+	Targets []string
+}
+
+// internalRanges are ranges that should never be routable from a tenant VM beyond its own subnet
+var internalRanges = []internalRange{
+	{"10.0.0.0/8", "RFC1918", []string{"10.0.0.1", "10.255.255.254"}},
+	{"172.16.0.0/12", "RFC1918", []string{"172.16.0.1", "172.31.255.254"}},
+	{"192.168.0.0/16", "RFC1918", []string{"192.168.0.1", "192.168.255.254"}},
+	{"100.64.0.0/10", "CGNAT", []string{"100.64.0.1", "100.127.255.254"}},
+	{"100.100.0.0/16", "provider-internal (Alibaba Cloud services)", []string{"100.100.100.200"}},
+	{"198.18.0.0/15", "provider-internal (benchmarking range)", []string{"198.18.0.1"}},
+}
+
+const (
+	internalMapMaxHops = 12
+	internalMapTCPPort = 443
+	internalMapUDPBase = 33434
+)
+
+type traceProbe struct {
+	Target string
+	Proto  string
+	TTL    int
+}
+
+type traceResult struct {
+	Hops        map[int]string // TTL -> address of the hop that answered
+	Reached     bool
+	Unreachable string // Destination unreachable reported by a router
+}
+
+// internalTracer sends ICMP, UDP and TCP SYN probes with increasing TTLs to every target at
+// once and matches the ICMP errors coming back to the probe that triggered them
+type internalTracer struct {
+	mu      sync.Mutex
+	probes  map[string]traceProbe
+	results map[string]map[string]*traceResult // target -> protocol -> result
+	icmpID  int
+}
+
+func newInternalTracer() *internalTracer {
+	return &internalTracer{
+		probes:  make(map[string]traceProbe),
+		results: make(map[string]map[string]*traceResult),
+		icmpID:  (os.Getpid() ^ 0x4854) & 0xffff,
+	}
+}
+
+func (t *internalTracer) result(target, proto string) *traceResult {
+	if t.results[target] == nil {
+		t.results[target] = make(map[string]*traceResult)
+	}
+	if t.results[target][proto] == nil {
+		t.results[target][proto] = &traceResult{Hops: make(map[int]string)}
+	}
+	return t.results[target][proto]
+}
+
+func (t *internalTracer) register(key string, probe traceProbe) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.probes[key] = probe
+	t.result(probe.Target, probe.Proto)
+}
+
+// record stores the answer to a probe. Answers from the target itself mark it as reached.
+func (t *internalTracer) record(key, from string, reached bool, unreachable string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	probe, ok := t.probes[key]
+	if !ok {
+		return
+	}
+	res := t.result(probe.Target, probe.Proto)
+	if from != "" {
+		res.Hops[probe.TTL] = from
+	}
+	if reached || from == probe.Target {
+		res.Reached = true
+	} else if unreachable != "" {
+		res.Unreachable = unreachable + " from " + from
+	}
+}
+
+func (t *internalTracer) listen(conn *icmp.PacketConn, deadline time.Time) {
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(deadline)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		from := peer.String()
+		msg, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case ipv4.ICMPTypeEchoReply:
+			if echo, ok := msg.Body.(*icmp.Echo); ok && echo.ID == t.icmpID {
+				t.record(fmt.Sprintf("icmp/%d", echo.Seq), from, true, "")
+			}
+		case ipv4.ICMPTypeTimeExceeded:
+			if body, ok := msg.Body.(*icmp.TimeExceeded); ok {
+				if key := t.innerProbeKey(body.Data); key != "" {
+					t.record(key, from, false, "")
+				}
+			}
+		case ipv4.ICMPTypeDestinationUnreachable:
+			if body, ok := msg.Body.(*icmp.DstUnreach); ok {
+				if key := t.innerProbeKey(body.Data); key != "" {
+					// Port unreachable from the target means the UDP probe got there
+					t.record(key, from, false, fmt.Sprintf("unreachable (code %d)", msg.Code))
+				}
+			}
+		}
+	}
+}
+
+// innerProbeKey identifies the probe quoted in an ICMP error (original IP header + 8 bytes)
+func (t *internalTracer) innerProbeKey(data []byte) string {
+	if len(data) < 20 {
+		return ""
+	}
+	ihl := int(data[0]&0x0f) * 4
+	if len(data) < ihl+8 {
+		return ""
+	}
+	inner := data[ihl:]
+	switch data[9] {
+	case syscall.IPPROTO_ICMP:
+		if int(binary.BigEndian.Uint16(inner[4:])) == t.icmpID {
+			return fmt.Sprintf("icmp/%d", binary.BigEndian.Uint16(inner[6:]))
+		}
+	case syscall.IPPROTO_UDP:
+		return fmt.Sprintf("udp/%d", binary.BigEndian.Uint16(inner[2:]))
+	case syscall.IPPROTO_TCP:
+		return fmt.Sprintf("tcp/%d", binary.BigEndian.Uint16(inner[0:]))
+	}
+	return ""
+}
+
+func (t *internalTracer) sendICMP(conn *icmp.PacketConn, target net.IP, ttl, seq int) error {
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: t.icmpID, Seq: seq, Data: []byte("hostile")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	t.register(fmt.Sprintf("icmp/%d", seq), traceProbe{target.String(), "ICMP", ttl})
+	if err := conn.IPv4PacketConn().SetTTL(ttl); err != nil {
+		return err
+	}
+	_, err = conn.WriteTo(data, &net.IPAddr{IP: target})
+	return err
+}
+
+func (t *internalTracer) sendUDP(conn *ipv4.PacketConn, target net.IP, ttl, port int) error {
+	t.register(fmt.Sprintf("udp/%d", port), traceProbe{target.String(), "UDP", ttl})
+	if err := conn.SetTTL(ttl); err != nil {
+		return err
+	}
+	_, err := conn.WriteTo([]byte("hostile"), nil, &net.UDPAddr{IP: target, Port: port})
+	return err
+}
+
+// sendTCP starts a TCP handshake with the given TTL. The socket is bound before connecting so
+// the source port, which identifies the probe in ICMP errors, is known up front.
+func (t *internalTracer) sendTCP(wg *sync.WaitGroup, target net.IP, ttl int, timeout time.Duration) {
+	var key string
+	dialer := net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl); sockErr != nil {
+					return
+				}
+				if sockErr = syscall.Bind(int(fd), &syscall.SockaddrInet4{}); sockErr != nil {
+					return
+				}
+				sa, err := syscall.Getsockname(int(fd))
+				if err != nil {
+					sockErr = err
+					return
+				}
+				if sa4, ok := sa.(*syscall.SockaddrInet4); ok {
+					key = fmt.Sprintf("tcp/%d", sa4.Port)
+					t.register(key, traceProbe{target.String(), "TCP", ttl})
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := dialer.DialContext(context.Background(), "tcp4", net.JoinHostPort(target.String(), fmt.Sprint(internalMapTCPPort)))
+		if err == nil {
+			conn.Close()
+			t.record(key, target.String(), true, "")
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			// A RST from the target still proves it was reached
+			t.record(key, target.String(), true, "")
+		}
+	}()
+}
+
+func routeDescription(target net.IP, defaultIface string) string {
+	routes, err := netlink.RouteGet(target)
+	if err != nil || len(routes) == 0 {
+		return "no route"
+	}
+	route := routes[0]
+	iface := fmt.Sprint(route.LinkIndex)
+	if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil {
+		iface = link.Attrs().Name
+	}
+	via := "on-link"
+	if route.Gw != nil {
+		via = "via " + route.Gw.String()
+	}
+	if iface == defaultIface {
+		return fmt.Sprintf("%s dev %s (default route interface)", via, iface)
+	}
+	return fmt.Sprintf("%s dev %s", via, iface)
+}
+
+func formatHops(res *traceResult) string {
+	if res == nil {
+		return "no probes sent"
+	}
+	ttls := make([]int, 0, len(res.Hops))
+	for ttl := range res.Hops {
+		ttls = append(ttls, ttl)
+	}
+	sort.Ints(ttls)
+
+	var hops []string
+	for _, ttl := range ttls {
+		hops = append(hops, fmt.Sprintf("%d=%s", ttl, res.Hops[ttl]))
+	}
+	summary := "no hops answered"
+	if len(hops) > 0 {
+		summary = "hops " + strings.Join(hops, " ")
+	}
+	if res.Reached {
+		summary += ", target reached"
+	} else if res.Unreachable != "" {
+		summary += ", " + res.Unreachable
+	}
+	return summary
+}
+
+// internalMapTargets splits the ranges into one entry per target, leaving out targets inside
+// our own subnet or outside the scope
+func internalMapTargets(ownNet *net.IPNet) []internalRange {
+	var targets []internalRange
+	for _, r := range internalRanges {
+		for _, target := range r.Targets {
+			if ownNet != nil && ownNet.Contains(net.ParseIP(target)) {
+				log.Printf("%s is inside our own subnet %s, skipping", target, ownNet)
+				continue
+			}
+			if !inScope(target) {
+				continue
+			}
+			targets = append(targets, internalRange{r.CIDR, r.Label, []string{target}})
+		}
+	}
+	return targets
+}
+
+// MapInternalNetworks traces representative addresses of private, CGNAT and provider-internal
+// ranges to show which of them are routable from the VM and how far the probes get
+func MapInternalNetworks() {
+	defaultIface, err := GetDefaultInterface(netlink.FAMILY_V4)
+	if err != nil {
+		log.Printf("Failed to detect the default network interface: %s", err.Error())
+		return
+	}
+	var ownNet *net.IPNet
+	if addr, err := GetInterfaceAddr(defaultIface, netlink.FAMILY_V4); err == nil {
+		ownNet = addr.IPNet
+	}
+
+	targets := internalMapTargets(ownNet)
+	if config.DryRun {
+		for _, r := range targets {
+			planAction("packets", r.Targets[0], fmt.Sprintf("ICMP echo, UDP and TCP SYN (port %d) with TTL 1-%d (%s %s)", internalMapTCPPort, internalMapMaxHops, r.Label, r.CIDR))
+		}
+		return
+	}
+
+	icmpConn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		log.Printf("Failed to open ICMP socket: %s", err.Error())
+		return
+	}
+	defer icmpConn.Close()
+
+	udpSock, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		log.Printf("Failed to open UDP socket: %s", err.Error())
+		return
+	}
+	defer udpSock.Close()
+	udpConn := ipv4.NewPacketConn(udpSock)
+
+	log.Printf("\nMapping internal network reachability via %s", defaultIface)
+	tracer := newInternalTracer()
+	timeout := 3 * time.Second
+
+	listenerDone := make(chan struct{})
+	go func() {
+		tracer.listen(icmpConn, time.Now().Add(internalMapMaxHops*50*time.Millisecond+2*timeout))
+		close(listenerDone)
+	}()
+
+	var wg sync.WaitGroup
+	seq := 0
+	for _, r := range targets {
+		ip := net.ParseIP(r.Targets[0]).To4()
+		for ttl := 1; ttl <= internalMapMaxHops; ttl++ {
+			seq++
+			tracer.sendICMP(icmpConn, ip, ttl, seq)
+			tracer.sendUDP(udpConn, ip, ttl, internalMapUDPBase+seq)
+			tracer.sendTCP(&wg, ip, ttl, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	wg.Wait()
+	<-listenerDone
+
+	reachable := 0
+	for _, r := range targets {
+		target := r.Targets[0]
+		log.Printf("%s (%s %s) route: %s", target, r.Label, r.CIDR, routeDescription(net.ParseIP(target), defaultIface))
+
+		beyond := false
+		for _, proto := range []string{"ICMP", "UDP", "TCP"} {
+			res := tracer.results[target][proto]
+			log.Printf("  %s: %s", proto, formatHops(res))
+			if res != nil && (res.Reached || len(res.Hops) > 1) {
+				beyond = true
+			}
+		}
+		if beyond {
+			reachable++
+			log.Printf("[NETWORK][InternalMap] %s range %s is routable beyond our subnet (probes to %s answered past the first hop)",
+				r.Label, r.CIDR, target)
+		}
+	}
+
+	if reachable == 0 {
+		log.Println("No internal ranges are routable beyond the first hop")
+	}
+}
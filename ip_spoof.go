@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -114,7 +115,22 @@ func GetExternalIPFromInterfaceWithTimeout(iface string, timeout time.Duration)
 	}
 }
 
+// Outcomes of a spoofing attempt that reached the connectivity test, anything else SpoofIP returns
+// means the attempt itself failed
+var (
+	errSpoofBlocked    = errors.New("no connectivity with the spoofed address")
+	errSpoofTranslated = errors.New("source rewritten after the change")
+)
+
 func SpoofIP(iface string, originalIP, newIP net.IP, mask net.IPMask) error {
+	if config.DryRun {
+		AddIP(iface, newIP, mask)
+		DeleteIP(iface, originalIP, mask)
+		planAction("http", "http://ip.me", fmt.Sprintf("GET via %s to check the external IP is now %s", iface, newIP))
+		planAction("netlink", iface, fmt.Sprintf("on failure: re-add %s and delete %s", originalIP, newIP))
+		return nil
+	}
+
 	log.Println("Adding neighbor IP...")
 	if err := AddIP(iface, newIP, mask); err != nil {
 		return fmt.Errorf("failed to add new IP: %w", err)
@@ -140,7 +156,7 @@ func SpoofIP(iface string, originalIP, newIP net.IP, mask net.IPMask) error {
 		AddIP(iface, originalIP, mask)
 		DeleteIP(iface, newIP, mask)
 
-		return fmt.Errorf("connectivity test failed: %w", err)
+		return fmt.Errorf("%w: %v", errSpoofBlocked, err)
 	}
 
 	log.Printf("Detected IP: %s", detectedIP)
@@ -150,7 +166,7 @@ func SpoofIP(iface string, originalIP, newIP net.IP, mask net.IPMask) error {
 	}
 
 	log.Printf("MISMATCH: expected %s, got %s", newIP, detectedIP)
-	return fmt.Errorf("IP mismatch after change")
+	return fmt.Errorf("%w: expected %s, got %s", errSpoofTranslated, newIP, detectedIP)
 }
 
 func FindLiveNeighbor(ipnet *net.IPNet, maxTries int, timeout time.Duration, iface string) (net.IP, error) {
@@ -163,6 +179,9 @@ func FindLiveNeighbor(ipnet *net.IPNet, maxTries int, timeout time.Duration, ifa
 
 	for _, neighborPrefix := range neighbors {
 		ip := net.IP(neighborPrefix.Addr().AsSlice())
+		if !inScope(ip.String()) {
+			continue
+		}
 		log.Printf("Trying to ping %s...", ip)
 
 		if PingIP(ip, timeout, iface) {
@@ -176,6 +195,47 @@ func FindLiveNeighbor(ipnet *net.IPNet, maxTries int, timeout time.Duration, ifa
 	return nil, fmt.Errorf("no live neighbors found after %d attempts", maxTries)
 }
 
+// FindKnownNeighbor returns a neighbour inside ipnet from the ARP/NDP table without sending any
+// packets. Gateways and routers are skipped, claiming their address would take down the link.
+func FindKnownNeighbor(ipnet *net.IPNet, iface string) (net.IP, error) {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface: %w", err)
+	}
+	family := netlink.FAMILY_V4
+	if ipnet.IP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	neighbors, err := netlink.NeighList(link.Attrs().Index, family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read neighbour table: %w", err)
+	}
+	routes, err := netlink.RouteList(link, family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes: %w", err)
+	}
+	gateways := make(map[string]bool)
+	for _, route := range routes {
+		if route.Gw != nil {
+			gateways[route.Gw.String()] = true
+		}
+	}
+	if gateway, _, err := GetDefaultGateway(family); err == nil {
+		gateways[gateway.String()] = true
+	}
+
+	for _, neigh := range neighbors {
+		if gateways[neigh.IP.String()] || neigh.Flags&netlink.NTF_ROUTER != 0 {
+			continue
+		}
+		if ipnet.Contains(neigh.IP) && !neigh.IP.Equal(ipnet.IP) && len(neigh.HardwareAddr) > 0 &&
+			neigh.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED) == 0 {
+			return neigh.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("no neighbours of %s other than gateways in the neighbour table", ipnet)
+}
+
 func PingIP(ip net.IP, timeout time.Duration, iface string) bool {
 	isIPv6 := ip.To4() == nil
 	var network, address string
@@ -221,10 +281,28 @@ func PingIP(ip net.IP, timeout time.Duration, iface string) bool {
 		return false
 	}
 
+	proto, replyType := 1, icmp.Type(ipv4.ICMPTypeEchoReply)
+	if isIPv6 {
+		proto, replyType = 58, ipv6.ICMPTypeEchoReply
+	}
+
+	// The raw socket sees every ICMP message for the host, so wait for the echo reply
+	// coming from the address we pinged
 	conn.SetReadDeadline(time.Now().Add(timeout))
 	reply := make([]byte, 1500)
-	_, _, err = conn.ReadFrom(reply)
-	return err == nil
+	for {
+		n, peer, err := conn.ReadFrom(reply)
+		if err != nil {
+			return false
+		}
+		peerAddr, ok := peer.(*net.IPAddr)
+		if !ok || !peerAddr.IP.Equal(ip) {
+			continue
+		}
+		if m, err := icmp.ParseMessage(proto, reply[:n]); err == nil && m.Type == replyType {
+			return true
+		}
+	}
 }
 
 func GetInterfaceAddr(interfaceName string, family int) (*netlink.Addr, error) {
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	ipmiPort                = 623
+	ipmiCmdGetChannelAuth   = 0x38
+	ipmiCmdGetChannelCipher = 0x54
+)
+
+// bmcResponse holds what a BMC revealed in reply to session-less requests
+type bmcResponse struct {
+	ASFPong     bool
+	AuthCaps    []byte // Get Channel Authentication Capabilities response data after the completion code
+	CipherZero  bool
+	CipherSuite []int
+}
+
+var ipv4Regex = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+
+// rmcpPresencePing builds an ASF presence ping (RMCP class 6, IANA 4542)
+func rmcpPresencePing(tag byte) []byte {
+	return []byte{0x06, 0x00, 0xff, 0x06, 0x00, 0x00, 0x11, 0xbe, 0x80, tag, 0x00, 0x00}
+}
+
+func ipmiChecksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+// ipmiRequest builds a session-less IPMI 1.5 request to the BMC (App netFn) wrapped in RMCP
+func ipmiRequest(cmd, seq byte, data []byte) []byte {
+	msg := []byte{0x20, 0x06 << 2}
+	msg = append(msg, ipmiChecksum(msg))
+	body := append([]byte{0x81, seq << 2, cmd}, data...)
+	body = append(body, ipmiChecksum(body))
+	msg = append(msg, body...)
+
+	// RMCP header, auth type none, session sequence and session ID zero, message length
+	packet := []byte{0x06, 0x00, 0xff, 0x07, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, byte(len(msg))}
+	return append(packet, msg...)
+}
+
+// parseIPMIResponse returns the command and the response data following a successful completion code
+func parseIPMIResponse(packet []byte) (byte, []byte, bool) {
+	if len(packet) < 22 || packet[0] != 0x06 || packet[3]&0x1f != 0x07 {
+		return 0, nil, false
+	}
+	// Session-less responses carry the 1.5 header: auth type, sequence, session ID, length
+	msgLen := int(packet[13])
+	if len(packet) < 14+msgLen || msgLen < 8 {
+		return 0, nil, false
+	}
+	msg := packet[14 : 14+msgLen]
+	if msg[6] != 0x00 {
+		return msg[5], nil, false
+	}
+	return msg[5], msg[7 : len(msg)-1], true
+}
+
+// dhcpLearnedAddresses extracts server, router, DNS and NTP addresses from DHCP lease files
+func dhcpLearnedAddresses() []string {
+	patterns := []string{
+		"/var/lib/dhcp/*.leases",
+		"/var/lib/dhclient/*.lease*",
+		"/var/lib/NetworkManager/*.lease",
+		"/run/systemd/netif/leases/*",
+	}
+
+	var addrs []string
+	for _, pattern := range patterns {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				continue
+			}
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.Contains(line, "server-identifier") || strings.Contains(line, "routers") ||
+					strings.Contains(line, "name-servers") || strings.Contains(line, "ntp-servers") ||
+					strings.HasPrefix(line, "SERVER_ADDRESS=") || strings.HasPrefix(line, "ROUTER=") ||
+					strings.HasPrefix(line, "DNS=") || strings.HasPrefix(line, "NTP=") {
+					addrs = append(addrs, ipv4Regex.FindAllString(line, -1)...)
+				}
+			}
+			f.Close()
+		}
+	}
+	return addrs
+}
+
+func bmcTargets() []net.IP {
+	var targets []net.IP
+	seen := make(map[string]bool)
+	add := func(s string) {
+		ip := net.ParseIP(s).To4()
+		if ip != nil && !seen[ip.String()] && !ip.IsLoopback() && !ip.IsUnspecified() && inScope(ip.String()) {
+			seen[ip.String()] = true
+			targets = append(targets, ip)
+		}
+	}
+
+	if gateway, _, err := GetDefaultGateway(netlink.FAMILY_V4); err == nil && gateway.To4() != nil {
+		add(gateway.String())
+		// BMCs are often numbered in the gateway's /24
+		network := gateway.To4().Mask(net.CIDRMask(24, 32))
+		for i := 1; i < 255; i++ {
+			add(net.IPv4(network[0], network[1], network[2], byte(i)).String())
+		}
+	}
+
+	if arpCache, err := getARPCache(); err == nil {
+		for _, entry := range arpCache {
+			add(entry.IP)
+		}
+	}
+
+	for _, addr := range dhcpLearnedAddresses() {
+		add(addr)
+	}
+
+	return targets
+}
+
+// probeBMCs sends an ASF presence ping and a Get Channel Authentication Capabilities request to
+// every target from a single socket and collects whatever answers before the timeout
+func probeBMCs(targets []net.IP, timeout time.Duration) (map[string]*bmcResponse, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	ping := rmcpPresencePing(0x01)
+	// Channel 0x0e (current) with the IPMI 2.0 extended data bit, requesting administrator level
+	authCaps := ipmiRequest(ipmiCmdGetChannelAuth, 0, []byte{0x8e, 0x04})
+	for _, ip := range targets {
+		dest := &net.UDPAddr{IP: ip, Port: ipmiPort}
+		conn.WriteTo(ping, dest)
+		conn.WriteTo(authCaps, dest)
+	}
+
+	responses := make(map[string]*bmcResponse)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1024)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		udpAddr, ok := peer.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		resp, ok := responses[udpAddr.IP.String()]
+		if !ok {
+			resp = &bmcResponse{}
+		}
+
+		packet := buf[:n]
+		if n >= 9 && packet[0] == 0x06 && packet[3]&0x1f == 0x06 && packet[8] == 0x40 {
+			resp.ASFPong = true
+		} else if cmd, data, ok := parseIPMIResponse(packet); ok && cmd == ipmiCmdGetChannelAuth && len(data) >= 4 {
+			resp.AuthCaps = append([]byte(nil), data...)
+		} else {
+			continue
+		}
+		responses[udpAddr.IP.String()] = resp
+	}
+
+	return responses, nil
+}
+
+// bmcCipherSuites lists the cipher suite IDs supported on the current channel (IPMI 2.0 only)
+func bmcCipherSuites(ip net.IP, timeout time.Duration) ([]int, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: ipmiPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var records []byte
+	buf := make([]byte, 1024)
+	for index := byte(0); index < 0x40; index++ {
+		// Channel 0x0e, payload type IPMI, list algorithms by cipher suite
+		request := ipmiRequest(ipmiCmdGetChannelCipher, index+1, []byte{0x0e, 0x00, 0x80 | index})
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		cmd, data, ok := parseIPMIResponse(buf[:n])
+		if !ok || cmd != ipmiCmdGetChannelCipher || len(data) < 1 {
+			return nil, fmt.Errorf("unexpected response to Get Channel Cipher Suites")
+		}
+		// First byte is the channel number, the rest is up to 16 bytes of record data
+		records = append(records, data[1:]...)
+		if len(data)-1 < 16 {
+			break
+		}
+	}
+
+	var suites []int
+	for i := 0; i+1 < len(records); i++ {
+		switch records[i] {
+		case 0xc0: // Standard cipher suite record
+			suites = append(suites, int(records[i+1]))
+			i++
+		case 0xc1: // OEM cipher suite record, followed by the IANA number
+			suites = append(suites, int(records[i+1]))
+			i += 4
+		}
+	}
+	return suites, nil
+}
+
+func describeAuthCaps(data []byte) []string {
+	var findings []string
+	authTypes := data[1]
+	status := data[2]
+
+	if status&0x01 != 0 {
+		findings = append(findings, "anonymous login enabled")
+	}
+	if status&0x02 != 0 {
+		findings = append(findings, "null usernames enabled")
+	}
+	if authTypes&0x01 != 0 {
+		findings = append(findings, "IPMI 1.5 auth type NONE supported")
+	}
+	if authTypes&0x10 != 0 {
+		findings = append(findings, "IPMI 1.5 straight password auth supported")
+	}
+	if authTypes&0x06 != 0 {
+		findings = append(findings, "IPMI 1.5 MD2/MD5 auth supported")
+	}
+	if authTypes&0x80 != 0 && len(data) >= 4 && data[3]&0x02 != 0 {
+		findings = append(findings, "IPMI 2.0 (RAKP password hashes retrievable for valid usernames)")
+		if status&0x20 == 0 {
+			findings = append(findings, "BMC key (Kg) is all zeros")
+		}
+	}
+	return findings
+}
+
+// CheckBMCExposure looks for BMCs answering RMCP/IPMI on UDP 623 from inside the guest
+func CheckBMCExposure() {
+	targets := bmcTargets()
+	if len(targets) == 0 {
+		log.Println("No targets found for the BMC/IPMI check")
+		return
+	}
+
+	log.Printf("\nProbing %d hosts for BMCs on UDP %d", len(targets), ipmiPort)
+	if config.DryRun {
+		hosts := make([]string, 0, len(targets))
+		for _, ip := range targets {
+			hosts = append(hosts, ip.String())
+		}
+		planAction("packets", strings.Join(hosts, ","), fmt.Sprintf("RMCP presence ping and IPMI Get Channel Authentication Capabilities to UDP %d, cipher suite query to responders", ipmiPort))
+		return
+	}
+	responses, err := probeBMCs(targets, 3*time.Second)
+	if err != nil {
+		log.Printf("BMC/IPMI check failed: %s", err.Error())
+		return
+	}
+
+	if len(responses) == 0 {
+		log.Println("No BMCs answered RMCP/IPMI requests")
+		return
+	}
+
+	hosts := make([]string, 0, len(responses))
+	for host := range responses {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		resp := responses[host]
+		var details []string
+		if resp.ASFPong {
+			details = append(details, "answers RMCP presence ping")
+		}
+		if resp.AuthCaps != nil {
+			details = append(details, describeAuthCaps(resp.AuthCaps)...)
+			if resp.AuthCaps[1]&0x80 != 0 {
+				if suites, err := bmcCipherSuites(net.ParseIP(host), 2*time.Second); err == nil {
+					resp.CipherSuite = suites
+					for _, id := range suites {
+						if id == 0 {
+							resp.CipherZero = true
+						}
+					}
+					details = append(details, fmt.Sprintf("cipher suites %v", suites))
+				}
+			}
+		}
+		if resp.CipherZero {
+			details = append(details, "cipher suite 0 enabled (authentication bypass)")
+		}
+
+		log.Printf("[NETWORK][BMC] BMC reachable at %s:%d/udp: %s", host, ipmiPort, strings.Join(details, ", "))
+	}
+}
@@ -2,32 +2,56 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/vishvananda/netlink"
 )
 
-func getARPCache() (map[string]string, error) {
+type arpEntry struct {
+	IP     string
+	MAC    string
+	Device string
+}
+
+// linkLocalHost is a neighbour identified by its MAC address on a given interface,
+// together with every address we know or can derive for it
+type linkLocalHost struct {
+	MAC        net.HardwareAddr
+	Device     string
+	IPv4       []string
+	IPv6       []string
+	LinkLocals map[string]string // link-local address -> form (eui-64, stable-privacy)
+	Router     bool
+}
+
+func getARPCache() ([]arpEntry, error) {
 	file, err := os.Open("/proc/net/arp")
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	arpTable := make(map[string]string)
+	var arpTable []arpEntry
 	scanner := bufio.NewScanner(file)
 
 	// Skip header line
 	scanner.Scan()
 
+	// IP address, HW type, Flags, HW address, Mask, Device
 	for scanner.Scan() {
 		fields := strings.Fields(scanner.Text())
-		if len(fields) >= 4 {
-			ip := fields[0]
-			mac := fields[3]
-			arpTable[ip] = mac
+		if len(fields) >= 6 {
+			arpTable = append(arpTable, arpEntry{
+				IP:     fields[0],
+				MAC:    fields[3],
+				Device: fields[5],
+			})
 		}
 	}
 
@@ -58,38 +82,172 @@ func MacToLinkLocal(mac net.HardwareAddr) net.IP {
 	return ip
 }
 
-func LinkLocalAccess() {
-	timeout := 2 * time.Second
+// collectLinkLocalHosts merges the IPv4 ARP cache and the IPv6 neighbour table into one
+// entry per MAC address and interface. EUI-64 link-local addresses are derived from the MAC,
+// while link-local addresses seen in the neighbour table that don't match the EUI-64 form
+// are stable-privacy (RFC 7217) or otherwise randomised addresses.
+func collectLinkLocalHosts() map[string]*linkLocalHost {
+	hosts := make(map[string]*linkLocalHost)
+	gateway, _, _ := GetDefaultGateway(netlink.FAMILY_V4)
+
+	getHost := func(mac net.HardwareAddr, device string) *linkLocalHost {
+		key := device + "/" + mac.String()
+		if h, ok := hosts[key]; ok {
+			return h
+		}
+		h := &linkLocalHost{MAC: mac, Device: device, LinkLocals: make(map[string]string)}
+		if linkLocal := MacToLinkLocal(mac); linkLocal != nil {
+			h.LinkLocals[linkLocal.String()] = "eui-64"
+		}
+		hosts[key] = h
+		return h
+	}
 
-	log.Println("\nGenerating link-local addresses from ARP cache:")
 	arpCache, err := getARPCache()
 	if err != nil {
 		log.Printf("Error reading ARP cache: %v\n", err)
-		return
 	}
-
-	for ipv4Str, macStr := range arpCache {
+	for _, entry := range arpCache {
 		// Skip incomplete entries
-		if macStr == "00:00:00:00:00:00" {
+		if entry.MAC == "00:00:00:00:00:00" {
 			continue
 		}
+		mac, err := net.ParseMAC(entry.MAC)
+		if err != nil {
+			continue
+		}
+		h := getHost(mac, entry.Device)
+		h.IPv4 = append(h.IPv4, entry.IP)
+		if gateway != nil && gateway.String() == entry.IP {
+			h.Router = true
+		}
+	}
 
-		mac, err := net.ParseMAC(macStr)
+	neighbors, err := netlink.NeighList(0, netlink.FAMILY_V6)
+	if err != nil {
+		log.Printf("Error reading IPv6 neighbour table: %v\n", err)
+	}
+	for _, neigh := range neighbors {
+		if len(neigh.HardwareAddr) != 6 || neigh.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED) != 0 {
+			continue
+		}
+		link, err := netlink.LinkByIndex(neigh.LinkIndex)
 		if err != nil {
 			continue
 		}
+		h := getHost(neigh.HardwareAddr, link.Attrs().Name)
+		if neigh.Flags&netlink.NTF_ROUTER != 0 {
+			h.Router = true
+		}
+		if !neigh.IP.IsLinkLocalUnicast() {
+			h.IPv6 = append(h.IPv6, neigh.IP.String())
+			continue
+		}
+		if _, ok := h.LinkLocals[neigh.IP.String()]; !ok {
+			h.LinkLocals[neigh.IP.String()] = "stable-privacy"
+		}
+	}
 
-		// Generate IPv6 link-local from MAC
-		linkLocal := MacToLinkLocal(mac)
-		if linkLocal == nil {
+	return hosts
+}
+
+// LinkLocalAccess tests every known neighbour for reachability over IPv6 link-local,
+// including TCP services that may be filtered on IPv4 but not on fe80::/64
+func LinkLocalAccess() {
+	timeout := 2 * time.Second
+
+	log.Println("\nTesting neighbour access via IPv6 link-local (ARP cache and IPv6 neighbours):")
+	hosts := collectLinkLocalHosts()
+	if len(hosts) == 0 {
+		log.Println("No neighbours found in the ARP cache or IPv6 neighbour table")
+		return
+	}
+
+	keys := make([]string, 0, len(hosts))
+	for key := range hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	reachable := 0
+	for _, key := range keys {
+		h := hosts[key]
+
+		addrs := make([]string, 0, len(h.LinkLocals))
+		for addr := range h.LinkLocals {
+			if inScope(addr) {
+				addrs = append(addrs, addr)
+			}
+		}
+		sort.Strings(addrs)
+
+		if config.DryRun {
+			for _, addr := range addrs {
+				planAction("packets", addr+"%"+h.Device, fmt.Sprintf("ICMPv6 echo and TCP connect to ports %v", config.LinkLocalPorts))
+			}
+			continue
+		}
+
+		var reached []string
+		openPorts := make(map[int]bool)
+		for _, addr := range addrs {
+			up := PingIP(net.ParseIP(addr), timeout, h.Device)
+			ports := scanTCPPorts(addr+"%"+h.Device, config.LinkLocalPorts, timeout)
+			if up || len(ports) > 0 {
+				reached = append(reached, addr+" ("+h.LinkLocals[addr]+")")
+			}
+			for _, port := range ports {
+				openPorts[port] = true
+			}
+		}
+
+		if len(reached) == 0 {
+			log.Printf("%s on %s is not reachable via link-local", h.MAC, h.Device)
 			continue
 		}
+		reachable++
 
-		up := PingIP(linkLocal, timeout, config.Interface)
-		if up {
-			log.Printf("[NETWORK] Neighbors can be accessed via IPv6 link-local. IPv4: %s, Link-local: %s.", ipv4Str, linkLocal)
-			return
+		role := ""
+		if h.Router {
+			role = " (router/hypervisor)"
 		}
+		log.Printf("[NETWORK][LinkLocal] Neighbour reachable via IPv6 link-local%s. Interface: %s, MAC: %s, IPv4: %s, IPv6: %s, Link-local: %s",
+			role, h.Device, h.MAC, strings.Join(h.IPv4, ","), strings.Join(h.IPv6, ","), strings.Join(reached, ", "))
+
+		for _, port := range sortedPorts(openPorts) {
+			// A service open on fe80:: but not on the neighbour's IPv4 address is most likely
+			// only protected by an IPv4 firewall
+			filtered := len(h.IPv4) > 0
+			for _, ipv4 := range h.IPv4 {
+				if inScope(ipv4) && tcpPortOpen(ipv4, port, timeout) {
+					filtered = false
+					break
+				}
+			}
+			if filtered {
+				log.Printf("[NETWORK][LinkLocal] %s port %d/tcp is reachable via link-local but not via IPv4 %s",
+					h.MAC, port, strings.Join(h.IPv4, ","))
+			} else {
+				log.Printf("[NETWORK][LinkLocal] %s port %d/tcp is reachable via link-local", h.MAC, port)
+			}
+		}
+	}
+
+	if config.DryRun {
+		return
+	}
+	if reachable == 0 {
+		log.Println("Accessing neighbors via link-local is not possible")
+		return
+	}
+	log.Printf("[NETWORK][LinkLocal] %d of %d neighbours are reachable via IPv6 link-local", reachable, len(hosts))
+}
+
+func sortedPorts(ports map[int]bool) []int {
+	sorted := make([]int, 0, len(ports))
+	for port := range ports {
+		sorted = append(sorted, port)
 	}
-	log.Println("Accessing neighbors via link-local is not possible")
+	sort.Ints(sorted)
+	return sorted
 }
@@ -7,7 +7,7 @@ import (
 
 func main() {
 	parseArgs()
-	if os.Geteuid() != 0 {
+	if config.Mode != "reflector" && os.Geteuid() != 0 {
 		log.Fatal("This program requires root privileges. Please run with sudo.")
 	}
 
@@ -20,12 +20,23 @@ func main() {
 		printDetectionResults(detection)
 		runRelevantChecks(detection)
 	case "network":
-		NetworkChecks()
+		_, platform := DetectPlatform()
+		NetworkChecks(platform)
+	case "reflector":
+		if err := RunReflector(config.Listen, config.ReflectorSecret, config.AllowTargets); err != nil {
+			log.Fatal(err)
+		}
 	case "all":
 		detection := DetectVirt()
 		printDetectionResults(detection)
 		runRelevantChecks(detection)
-		NetworkChecks()
+		NetworkChecks(detection.PlatformName)
+	}
+
+	if config.DryRun {
+		if err := writePlanReport(); err != nil {
+			log.Println(err.Error())
+		}
 	}
 }
 
@@ -41,11 +52,55 @@ func printDetectionResults(d Detection) {
 }
 
 func runRelevantChecks(d Detection) {
+	var checks []check
 	if d.Container {
 		if d.ContainerName == "lxc" {
-			CheckLXCPrivilegedContainer()
-			CheckLXCCgroupLimits()
-			CheckIPv6RouterAdvertisements()
+			checks = append(checks,
+				check{"lxc-privileged", ImpactPassive, func() { CheckLXCPrivilegedContainer() }},
+				check{"lxc-cgroup-limits", ImpactPassive, func() { CheckLXCCgroupLimits() }},
+				check{"ipv6-router-advertisements", ImpactPassive, func() { CheckIPv6RouterAdvertisements() }},
+			)
 		}
 	}
+	if d.VM {
+		checks = append(checks,
+			check{"cpuid", ImpactPassive, func() { CheckCPUID() }},
+			check{"pci-devices", ImpactPassive, func() { CheckPCIDevices() }},
+			check{"smbios", ImpactPassive, func() { CheckSMBIOS() }},
+			check{"acpi-tables", ImpactPassive, ACPIInventory},
+		)
+	}
+	if d.HypervisorName == "kvm" {
+		checks = append(checks,
+			check{"fw-cfg", ImpactPassive, func() { CheckFwCfg() }},
+			check{"guest-agent", ImpactPassive, func() { CheckGuestAgent() }},
+		)
+	}
+	if d.HypervisorName == "xen" {
+		checks = append(checks,
+			check{"xenstore-access", ImpactPassive, func() { CheckXenstoreAccess() }},
+			check{"xenstore-write-home", ImpactActiveLocal, func() { CheckXenstoreWriteHome() }},
+			// Probe keys written outside our home are visible to dom0 and the toolstack
+			check{"xenstore-write-foreign", ImpactDisruptive, func() { CheckXenstoreWriteForeign() }},
+		)
+	}
+	if d.PlatformName == "xcp-ng" {
+		checks = append(checks,
+			check{"xcp-ng-guest-tools", ImpactPassive, func() { CheckXCPNGGuestTools() }},
+			check{"xcp-ng-xenstore-permissions", ImpactPassive, func() { CheckXCPNGXenstorePermissions() }},
+		)
+	}
+	if HasCloudInit() {
+		checks = append(checks,
+			check{"cloud-init-secrets", ImpactPassive, func() { CheckCloudInitSecrets() }},
+			check{"cloud-init-provider-keys", ImpactPassive, func() { CheckCloudInitProviderKeys() }},
+			check{"cloud-init-permissions", ImpactPassive, func() { CheckCloudInitPermissions() }},
+		)
+	}
+	checks = append(checks,
+		check{"provisioning-secrets", ImpactPassive, func() { CheckProvisioningSecrets(d.PlatformName) }},
+		check{"metadata-audit", ImpactPassive, func() { AuditMetadata() }},
+		check{"config-drive", ImpactActiveLocal, func() { CheckConfigDrives() }},
+	)
+	runChecks(checks)
 }
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vishvananda/netlink"
+)
+
+const metadataIPv4URL = "http://169.254.169.254"
+
+// metadataProvider describes one style of metadata API. Providers that protect the API require
+// either fixed request headers or a session token obtained with a PUT request.
+type metadataProvider struct {
+	Name           string
+	Probe          string
+	Headers        map[string]string
+	TokenPath      string
+	TokenTTLHeader string
+	TokenHeader    string
+	SecretPaths    []string
+}
+
+var metadataProviders = []metadataProvider{
+	{
+		Name:  "OpenStack",
+		Probe: "/openstack/latest/meta_data.json",
+		SecretPaths: []string{
+			"/openstack/latest/user_data",
+			"/openstack/latest/vendor_data.json",
+			"/openstack/latest/vendor_data2.json",
+			"/openstack/latest/password",
+		},
+	},
+	{
+		Name:           "EC2",
+		Probe:          "/latest/meta-data/instance-id",
+		TokenPath:      "/latest/api/token",
+		TokenTTLHeader: "X-aws-ec2-metadata-token-ttl-seconds",
+		TokenHeader:    "X-aws-ec2-metadata-token",
+		SecretPaths: []string{
+			"/latest/user-data",
+			"/latest/meta-data/iam/security-credentials/",
+			"/latest/meta-data/identity-credentials/ec2/security-credentials/ec2-instance",
+		},
+	},
+	{
+		Name:    "GCE",
+		Probe:   "/computeMetadata/v1/instance/id",
+		Headers: map[string]string{"Metadata-Flavor": "Google"},
+		SecretPaths: []string{
+			"/computeMetadata/v1/instance/attributes/?recursive=true",
+			"/computeMetadata/v1/project/attributes/?recursive=true",
+			"/computeMetadata/v1/instance/service-accounts/default/token",
+		},
+	},
+	{
+		Name:    "Azure",
+		Probe:   "/metadata/instance?api-version=2021-02-01",
+		Headers: map[string]string{"Metadata": "true"},
+		SecretPaths: []string{
+			"/metadata/instance/compute/userData?api-version=2021-01-01&format=text",
+			"/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https://management.azure.com/",
+		},
+	},
+	{
+		Name:  "DigitalOcean",
+		Probe: "/metadata/v1.json",
+		SecretPaths: []string{
+			"/metadata/v1/user-data",
+			"/metadata/v1/vendor-data",
+		},
+	},
+	{
+		Name:        "Hetzner",
+		Probe:       "/hetzner/v1/metadata",
+		SecretPaths: []string{"/hetzner/v1/userdata"},
+	},
+	{
+		Name:        "Vultr",
+		Probe:       "/v1.json",
+		SecretPaths: []string{"/latest/user-data"},
+	},
+	{
+		Name:           "Linode",
+		Probe:          "/v1/instance",
+		TokenPath:      "/v1/token",
+		TokenTTLHeader: "Metadata-Token-Expiry-Seconds",
+		TokenHeader:    "Metadata-Token",
+		SecretPaths:    []string{"/v1/user-data"},
+	},
+	{
+		Name:        "Oracle",
+		Probe:       "/opc/v2/instance/",
+		Headers:     map[string]string{"Authorization": "Bearer Oracle"},
+		SecretPaths: []string{"/opc/v2/instance/metadata/user_data"},
+	},
+}
+
+// metadataClient talks to one metadata endpoint. The base URL and HTTP client are configurable so
+// the audit can run against a local stand-in server or with a client bound to another source address.
+type metadataClient struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func newMetadataClient(baseURL string, client *http.Client) *metadataClient {
+	if client == nil {
+		client = &http.Client{Timeout: 3 * time.Second}
+	}
+	return &metadataClient{BaseURL: strings.TrimRight(baseURL, "/"), Client: client}
+}
+
+func (m *metadataClient) request(method, path string, headers map[string]string) (int, []byte, error) {
+	req, err := http.NewRequest(method, m.BaseURL+path, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("User-Agent", "curl/8.0.0")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, secretScanMaxSize))
+	return resp.StatusCode, body, err
+}
+
+// reachable reports whether anything answers HTTP at the base URL
+func (m *metadataClient) reachable() bool {
+	_, _, err := m.request("GET", "/", nil)
+	return err == nil
+}
+
+// authHeaders returns the headers that authenticate a request to the provider, fetching a
+// session token first when the provider uses one
+func (m *metadataClient) authHeaders(p metadataProvider) map[string]string {
+	headers := make(map[string]string)
+	for name, value := range p.Headers {
+		headers[name] = value
+	}
+	if p.TokenPath != "" {
+		status, token, err := m.request("PUT", p.TokenPath, map[string]string{p.TokenTTLHeader: "300"})
+		if err == nil && status == http.StatusOK && len(token) > 0 {
+			headers[p.TokenHeader] = strings.TrimSpace(string(token))
+		}
+	}
+	return headers
+}
+
+// detect reports whether the provider's API answers and whether it also answers without the
+// headers or session token that are supposed to protect it
+func (m *metadataClient) detect(p metadataProvider) (present, unprotected bool, headers map[string]string) {
+	openStatus, _, err := m.request("GET", p.Probe, nil)
+	if err != nil {
+		return false, false, nil
+	}
+	headers = m.authHeaders(p)
+	status := openStatus
+	if len(headers) > 0 {
+		status, _, _ = m.request("GET", p.Probe, headers)
+	}
+	present = status == http.StatusOK || openStatus == http.StatusOK
+	unprotected = openStatus == http.StatusOK && (len(p.Headers) > 0 || p.TokenPath != "")
+	return present, unprotected, headers
+}
+
+// scanMetadataBody scans a metadata document for credentials, including base64 encoded user-data
+func scanMetadataBody(source string, body []byte) int {
+	findings := scanReaderForSecrets(source, bytes.NewReader(body))
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(body))); err == nil && len(decoded) > 0 && utf8.Valid(decoded) {
+		findings += scanReaderForSecrets(source+" (base64 decoded)", bytes.NewReader(decoded))
+	}
+	return findings
+}
+
+// auditProvider fetches the provider's user-data, vendor-data, password and credential
+// endpoints and reports what an unprivileged process in the VM can read
+func (m *metadataClient) auditProvider(p metadataProvider, headers map[string]string) int {
+	findings := 0
+	for _, path := range p.SecretPaths {
+		status, body, err := m.request("GET", path, headers)
+		if err != nil || status != http.StatusOK || len(bytes.TrimSpace(body)) == 0 {
+			continue
+		}
+		source := m.BaseURL + path
+
+		switch {
+		case strings.HasSuffix(path, "/password"):
+			log.Printf("[Metadata][%s] WARNING: %s holds an (encrypted) admin password (%d bytes)", p.Name, source, len(body))
+			findings++
+			continue
+		case strings.HasSuffix(path, "/security-credentials/"):
+			// Directory listing of IAM roles, each one has its own credentials
+			for _, role := range strings.Fields(string(body)) {
+				if status, creds, err := m.request("GET", path+role, headers); err == nil && status == http.StatusOK {
+					log.Printf("[Metadata][%s] WARNING: temporary credentials for IAM role %s readable at %s", p.Name, role, source+role)
+					findings += 1 + scanMetadataBody(source+role, creds)
+				}
+			}
+			continue
+		case strings.Contains(path, "token") || strings.Contains(path, "credentials"):
+			log.Printf("[Metadata][%s] WARNING: access token readable at %s", p.Name, source)
+			findings++
+		default:
+			log.Printf("[Metadata][%s] %s readable (%d bytes)", p.Name, source, len(body))
+		}
+		findings += scanMetadataBody(source, body)
+	}
+	return findings
+}
+
+// metadataBaseURLs returns the IPv4 endpoint, the EC2 IPv6 endpoint and the link-local IPv6
+// endpoint on every interface
+func metadataBaseURLs() []string {
+	urls := []string{metadataIPv4URL, "http://[fd00:ec2::254]"}
+	if interfaces, err := ListInterfaces(netlink.FAMILY_V6); err == nil {
+		for _, iface := range interfaces {
+			urls = append(urls, "http://[fe80::a9fe:a9fe%25"+iface+"]")
+		}
+	}
+	return urls
+}
+
+// AuditMetadata enumerates the metadata APIs reachable over IPv4 and IPv6, whether they require
+// a token or header, and what secrets they hand out
+func AuditMetadata() bool {
+	findings := 0
+	found := false
+	for _, baseURL := range metadataBaseURLs() {
+		m := newMetadataClient(baseURL, nil)
+		if !m.reachable() {
+			log.Printf("[Metadata] No metadata service at %s", baseURL)
+			continue
+		}
+		for _, p := range metadataProviders {
+			present, unprotected, headers := m.detect(p)
+			if !present {
+				continue
+			}
+			found = true
+			log.Printf("[Metadata][%s] Metadata API reachable at %s", p.Name, baseURL)
+			if baseURL != metadataIPv4URL {
+				log.Printf("[Metadata][%s] WARNING: metadata is also served over IPv6 at %s, check that IPv6 firewall rules cover it", p.Name, baseURL)
+			}
+			if unprotected {
+				log.Printf("[Metadata][%s] WARNING: %s answers without the session token/header (SSRF-exploitable)", p.Name, baseURL+p.Probe)
+				findings++
+			}
+			findings += m.auditProvider(p, headers)
+		}
+	}
+
+	if !found {
+		log.Println("[Metadata] No metadata API found")
+		return true
+	}
+	if findings > 0 {
+		log.Printf("[Metadata][Summary] %d metadata findings - review warnings above", findings)
+		return false
+	}
+	log.Println("[Metadata][Summary] Metadata service requires authentication and exposes no secrets")
+	return true
+}
+
+// sourceBoundClient returns an HTTP client whose connections originate from source
+func sourceBoundClient(source net.IP, timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: source}, Timeout: timeout}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "tcp4", addr)
+			},
+		},
+	}
+}
+
+// CheckMetadataSpoofedSource requests our metadata again from a neighbour's address. If the
+// service identifies instances by source IP it answers with the neighbour's metadata.
+func CheckMetadataSpoofedSource() {
+	iface, err := GetDefaultInterface(netlink.FAMILY_V4)
+	if err != nil {
+		log.Printf("Failed to detect default interface: %s", err.Error())
+		return
+	}
+	addr, err := GetInterfaceAddr(iface, netlink.FAMILY_V4)
+	if err != nil {
+		log.Printf("Failed to get interface address: %s", err.Error())
+		return
+	}
+	neighbor, err := FindKnownNeighbor(addr.IPNet, iface)
+	if err != nil || !inScope(neighbor.String()) {
+		log.Println("[Metadata][SpoofedSource] No in-scope neighbour to spoof")
+		return
+	}
+
+	own := newMetadataClient(metadataIPv4URL, nil)
+	var provider *metadataProvider
+	var headers map[string]string
+	var ownBody []byte
+	for i := range metadataProviders {
+		if present, _, h := own.detect(metadataProviders[i]); present {
+			provider, headers = &metadataProviders[i], h
+			_, ownBody, _ = own.request("GET", provider.Probe, headers)
+			break
+		}
+	}
+	if provider == nil {
+		log.Println("[Metadata][SpoofedSource] No metadata API found")
+		return
+	}
+
+	if err := AddHostIP(iface, neighbor); err != nil {
+		log.Printf("Failed to add neighbour address %s: %s", neighbor, err.Error())
+		return
+	}
+	defer DeleteHostIP(iface, neighbor)
+	if dryRunSkip("http", metadataIPv4URL+provider.Probe, "GET sourced from neighbour "+neighbor.String()) {
+		return
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	spoofed := newMetadataClient(metadataIPv4URL, sourceBoundClient(neighbor, 5*time.Second))
+	status, body, err := spoofed.request("GET", provider.Probe, spoofed.authHeaders(*provider))
+	switch {
+	case err != nil || status != http.StatusOK:
+		log.Printf("[Metadata][SpoofedSource] Metadata service did not answer requests from neighbour %s", neighbor)
+	case !bytes.Equal(body, ownBody):
+		log.Printf("[Metadata][SpoofedSource] WARNING: metadata service answered a request from neighbour %s with different instance data: %s",
+			neighbor, truncate(strings.TrimSpace(string(body)), 120))
+	default:
+		log.Printf("[Metadata][SpoofedSource] Metadata service answered neighbour %s with our own instance data", neighbor)
+	}
+}
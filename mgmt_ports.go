@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+type managementService struct {
+	Ports   string
+	Product string
+}
+
+// managementServices are the services a tenant should never reach on the node or the
+// provider's infrastructure, grouped by the platform that exposes them
+var managementServices = map[string][]managementService{
+	"proxmox": {
+		{"8006", "Proxmox VE web UI/API"},
+		{"3128", "Proxmox SPICE proxy"},
+		{"5900-5999", "VNC console"},
+		{"111", "rpcbind"},
+	},
+	"xcp-ng": {
+		{"443", "XAPI (HTTPS)"},
+		{"80", "XAPI (HTTP)"},
+	},
+	"vmware": {
+		{"443", "ESXi/vCenter web UI/API"},
+		{"902", "ESXi authd/VMRC console"},
+		{"5989", "ESXi CIM (SFCB)"},
+		{"8697", "ESXi VVOLD"},
+	},
+	"openstack": {
+		{"16509", "libvirt (TCP)"},
+		{"16514", "libvirt (TLS)"},
+		{"5900-5999", "VNC console"},
+		{"6080", "noVNC proxy"},
+	},
+	"libvirt": {
+		{"16509", "libvirt (TCP)"},
+		{"16514", "libvirt (TLS)"},
+	},
+	"solusvm": {
+		{"5353", "SolusVM master (HTTPS)"},
+		{"5656", "SolusVM master (HTTP)"},
+		{"443", "SolusVM 2 web UI"},
+	},
+	"virtualizor": {
+		{"4082", "Virtualizor admin (HTTP)"},
+		{"4083", "Virtualizor admin (HTTPS)"},
+		{"4084", "Virtualizor enduser (HTTP)"},
+		{"4085", "Virtualizor enduser (HTTPS)"},
+	},
+	"common": {
+		{"22", "SSH"},
+		{"9090", "Cockpit"},
+		{"10000", "Webmin"},
+	},
+}
+
+// managementFingerprints map strings found in banners, HTTP responses and TLS certificates to products
+var managementFingerprints = []struct {
+	Needle  string
+	Product string
+}{
+	{"proxmox", "Proxmox VE"},
+	{"pve-api-daemon", "Proxmox VE"},
+	{"pve cluster manager", "Proxmox VE"},
+	{"xcp-ng", "XCP-ng"},
+	{"xenserver", "Citrix XenServer"},
+	{"xen orchestra", "Xen Orchestra"},
+	{"xapi", "XAPI"},
+	{"vmware authentication daemon", "VMware ESXi authd"},
+	{"id_eesx_welcome", "VMware ESXi"},
+	{"vmware esxi", "VMware ESXi"},
+	{"vmware", "VMware"},
+	{"libvirt", "libvirt"},
+	{"solusvm", "SolusVM"},
+	{"virtualizor", "Virtualizor"},
+	{"cockpit", "Cockpit"},
+	{"webmin", "Webmin"},
+	{"novnc", "noVNC"},
+	{"rfb 003", "VNC server"},
+	{"ssh-", "SSH"},
+}
+
+type managementTarget struct {
+	Host  string
+	Label string
+}
+
+// managementPortList returns the ports to scan, the products expected on each port and the ports
+// of the detected platform's own services
+func managementPortList(platform string) ([]int, map[int]string, map[int]bool) {
+	var ports []int
+	names := make(map[int]string)
+	platformPorts := make(map[int]bool)
+
+	order := make([]string, 0, len(managementServices))
+	for name := range managementServices {
+		order = append(order, name)
+	}
+	sort.Strings(order)
+
+	for _, name := range order {
+		for _, svc := range managementServices[name] {
+			svcPorts, err := parsePorts(svc.Ports)
+			if err != nil {
+				continue
+			}
+			for _, port := range svcPorts {
+				if name == platform {
+					platformPorts[port] = true
+				}
+				if _, ok := names[port]; ok {
+					names[port] += " / " + svc.Product
+					continue
+				}
+				names[port] = svc.Product
+				ports = append(ports, port)
+			}
+		}
+	}
+
+	return ports, names, platformPorts
+}
+
+func managementTargets() []managementTarget {
+	var targets []managementTarget
+	seen := make(map[string]bool)
+	add := func(host, label string) {
+		if !seen[host] && inScope(host) {
+			seen[host] = true
+			targets = append(targets, managementTarget{host, label})
+		}
+	}
+
+	gateway, _, err := GetDefaultGateway(netlink.FAMILY_V4)
+	if err != nil {
+		log.Printf("Failed to detect the default gateway: %s", err.Error())
+	} else {
+		add(gateway.String(), "gateway")
+
+		// Nodes of the same cluster usually sit right next to the gateway
+		if addr, ok := netip.AddrFromSlice(gateway.To4()); ok {
+			for _, neighbor := range generateNeighborIPs(netip.PrefixFrom(addr, 32), 8) {
+				add(neighbor.Addr().String(), "gateway neighbour")
+			}
+		}
+	}
+
+	if gateway6, iface, err := GetDefaultGateway(netlink.FAMILY_V6); err == nil {
+		host := gateway6.String()
+		if gateway6.IsLinkLocalUnicast() {
+			host += "%" + iface
+		}
+		add(host, "IPv6 gateway")
+	}
+
+	for _, h := range collectLinkLocalHosts() {
+		for addr := range h.LinkLocals {
+			add(addr+"%"+h.Device, "link-local neighbour "+h.MAC.String())
+		}
+	}
+
+	return targets
+}
+
+// CheckManagementExposure scans the gateway, its neighbours and link-local neighbours for
+// hypervisor and infrastructure management services
+func CheckManagementExposure(platform string) {
+	timeout := 1 * time.Second
+	ports, names, platformPorts := managementPortList(platform)
+	targets := managementTargets()
+
+	log.Printf("\nScanning %d hosts for management services (%d ports)", len(targets), len(ports))
+
+	if config.DryRun {
+		for _, target := range targets {
+			planAction("packets", target.Host, fmt.Sprintf("TCP connect to %d management ports (%s), banner/TLS/HTTP fingerprint of open ports", len(ports), target.Label))
+		}
+		return
+	}
+
+	exposed := 0
+	for _, target := range targets {
+		// The detected platform's own services are reported first, in their own block
+		var own, other []int
+		for _, port := range scanTCPPorts(target.Host, ports, timeout) {
+			if platformPorts[port] {
+				own = append(own, port)
+			} else {
+				other = append(other, port)
+			}
+		}
+		report := func(port int) {
+			exposed++
+			product := fingerprintService(target.Host, port, 3*time.Second)
+			if product == "" {
+				product = "unconfirmed"
+			}
+			log.Printf("[NETWORK][Management] %s port %d/tcp open (%s). Expected: %s, Fingerprint: %s",
+				target.Host, port, target.Label, names[port], product)
+		}
+		if len(own) > 0 {
+			log.Printf("[NETWORK][Management] %s (%s) exposes %s management services:", target.Host, target.Label, platform)
+			for _, port := range own {
+				report(port)
+			}
+			if len(other) > 0 {
+				log.Printf("[NETWORK][Management] %s (%s) other management services:", target.Host, target.Label)
+			}
+		}
+		for _, port := range other {
+			report(port)
+		}
+	}
+
+	if exposed == 0 {
+		log.Println("No management services reachable from this host")
+	}
+}
+
+// fingerprintService names the product behind an open port from its banner, TLS certificate or HTTP response
+func fingerprintService(host string, port int, timeout time.Duration) string {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	var evidence []string
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	// Server-first protocols (SSH, VNC, authd) greet us without a request
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	banner, _ := bufio.NewReader(conn).ReadString('\n')
+	banner = strings.TrimSpace(banner)
+	if banner != "" {
+		evidence = append(evidence, "banner: "+truncate(banner, 80))
+	} else {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err == nil {
+			if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+				cert := certs[0]
+				evidence = append(evidence, fmt.Sprintf("TLS subject: %s, issuer: %s", cert.Subject, cert.Issuer))
+				if len(cert.DNSNames) > 0 {
+					evidence = append(evidence, "TLS names: "+strings.Join(cert.DNSNames, ","))
+				}
+			}
+			evidence = append(evidence, httpFingerprint(tlsConn, host))
+			tlsConn.Close()
+		} else if plain, err := net.DialTimeout("tcp", address, timeout); err == nil {
+			plain.SetDeadline(time.Now().Add(timeout))
+			evidence = append(evidence, httpFingerprint(plain, host))
+			plain.Close()
+		}
+	}
+
+	summary := strings.Join(evidence, "; ")
+	lower := strings.ToLower(summary)
+	for _, fp := range managementFingerprints {
+		if strings.Contains(lower, fp.Needle) {
+			return fp.Product + " [" + summary + "]"
+		}
+	}
+	return summary
+}
+
+func httpFingerprint(conn net.Conn, host string) string {
+	fmt.Fprintf(conn, "GET / HTTP/1.0\r\nHost: %s\r\nUser-Agent: curl/8.0.0\r\n\r\n", host)
+	data, _ := io.ReadAll(io.LimitReader(conn, 16384))
+	if len(data) == 0 {
+		return ""
+	}
+
+	var parts []string
+	response := string(data)
+	if line, _, ok := strings.Cut(response, "\r\n"); ok {
+		parts = append(parts, truncate(line, 60))
+	}
+	for _, line := range strings.Split(response, "\r\n") {
+		if strings.HasPrefix(strings.ToLower(line), "server:") {
+			parts = append(parts, strings.TrimSpace(line))
+		}
+	}
+	lower := strings.ToLower(response)
+	if start := strings.Index(lower, "<title>"); start >= 0 {
+		if end := strings.Index(lower[start:], "</title>"); end > 0 {
+			parts = append(parts, "title: "+strings.TrimSpace(response[start+7:start+end]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
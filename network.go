@@ -1,80 +1,221 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/vishvananda/netlink"
 )
 
-func NetworkChecks() {
+// networkResult summarises the network test of one interface and address family
+type networkResult struct {
+	Interface    string
+	Family       string
+	DefaultRoute bool
+	Address      string
+	ExternalIP   string
+	Neighbor     string
+	Spoofing     string
+}
+
+func NetworkChecks(platform string) {
+	families := []int{netlink.FAMILY_V4, netlink.FAMILY_V6}
 	if config.Ipv4 {
-		log.Println("Testing IPv4 network")
-		TestNetwork(netlink.FAMILY_V4)
+		families = []int{netlink.FAMILY_V4}
 	} else if config.Ipv6 {
-		log.Println("Testing IPv6 network")
-		TestNetwork(netlink.FAMILY_V6)
-	} else {
-		log.Println("Testing IPv4 network")
-		TestNetwork(netlink.FAMILY_V4)
-		log.Println("Testing IPv6 network")
-		TestNetwork(netlink.FAMILY_V6)
+		families = []int{netlink.FAMILY_V6}
+	}
+
+	// Spoofing takes over addresses (possibly a neighbour's) and removes our own
+	var results []networkResult
+	if allowCheck("spoofing", ImpactDisruptive) {
+		for _, family := range families {
+			interfaces, err := networkInterfaces(family)
+			if err != nil {
+				log.Printf("Failed to list network interfaces: %s", err.Error())
+				continue
+			}
+			for _, iface := range interfaces {
+				log.Printf("Testing %s network on %s", familyName(family), iface)
+				if result, err := TestNetwork(iface, family); err == nil {
+					results = append(results, result)
+				}
+			}
+		}
+		printNetworkSummary(results)
+	}
+
+	checks := []check{
+		{"link-local-access", ImpactActiveNetwork, LinkLocalAccess},
+		{"management-exposure", ImpactActiveNetwork, func() { CheckManagementExposure(platform) }},
+		{"bmc-exposure", ImpactActiveNetwork, CheckBMCExposure},
+		{"storage-exposure", ImpactActiveNetwork, func() { CheckStorageExposure(platform) }},
+		{"metadata-spoofed-source", ImpactDisruptive, CheckMetadataSpoofedSource},
+		{"vsock-scan", ImpactActiveNetwork, ScanVsock},
+	}
+	if config.InternalMap {
+		checks = append(checks, check{"internal-map", ImpactActiveNetwork, MapInternalNetworks})
+	}
+	runChecks(checks)
+
+	if platform == "xcp-ng" {
+		ReportXCPNGVIFLocking(results)
 	}
-	LinkLocalAccess()
 }
 
-func TestNetwork(family int) error {
-	familyName := "IPv4"
+func familyName(family int) string {
 	if family == netlink.FAMILY_V6 {
-		familyName = "IPv6"
+		return "IPv6"
 	}
+	return "IPv4"
+}
 
+// networkInterfaces returns the interfaces given with -interface, or every interface that is up
+// and has an address of the family
+func networkInterfaces(family int) ([]string, error) {
 	if config.Interface == "" {
-		var err error
-		config.Interface, err = GetDefaultInterface(family)
-		if err != nil {
-			log.Printf("Failed to detect the default network interface. Specify it with -interface: %s", err.Error())
-			return err
+		return ListInterfaces(family)
+	}
+
+	var interfaces []string
+	for _, iface := range strings.Split(config.Interface, ",") {
+		if iface = strings.TrimSpace(iface); iface != "" {
+			interfaces = append(interfaces, iface)
 		}
-		log.Printf("Detected interface with default route or public address: %s", config.Interface)
 	}
+	return interfaces, nil
+}
 
-	addr, err := GetInterfaceAddr(config.Interface, family)
+func TestNetwork(iface string, family int) (networkResult, error) {
+	result := networkResult{Interface: iface, Family: familyName(family)}
+
+	if _, defaultIface, err := GetDefaultGateway(family); err == nil && defaultIface == iface {
+		result.DefaultRoute = true
+		log.Printf("%s has the default %s route", iface, result.Family)
+	}
+
+	addr, err := GetInterfaceAddr(iface, family)
 	if err != nil {
 		log.Printf("Failed to get interface address: %s", err.Error())
-		return err
+		return result, err
 	}
+	result.Address = addr.IPNet.String()
 
-	externalIP, err := GetExternalIP(family)
+	// The default route interface can use any connection, other interfaces (eg. private networks)
+	// need connections bound to their own address
+	var externalIP string
+	if result.DefaultRoute {
+		externalIP, err = GetExternalIP(family)
+	} else {
+		externalIP, err = GetExternalIPFromSource(addr.IP, 10*time.Second)
+	}
 	if err != nil {
-		log.Printf("Failed to get external IP address: %s", err.Error())
-		return err
+		log.Printf("Failed to get external IP address via %s: %s", iface, err.Error())
+		log.Printf("%s has no external connectivity, egress spoofing tests against ip.me will not be conclusive", iface)
+	} else {
+		result.ExternalIP = externalIP
+		if !addr.IP.Equal(net.ParseIP(externalIP)) {
+			log.Printf("[NETWORK][%s][%s] NAT detected. External IP: %s, Interface IP: %s",
+				iface, result.Family, externalIP, addr.IP.String())
+		}
 	}
 
-	if !addr.IP.Equal(net.ParseIP(externalIP)) {
-		log.Printf("[NETWORK][%s][%s] NAT detected. External IP: %s, Interface IP: %s",
-			config.Interface, familyName, externalIP, addr.IP.String())
+	var neighborIP net.IP
+	if config.DryRun {
+		neighborIP, err = FindKnownNeighbor(addr.IPNet, iface)
+		planAction("packets", iface, fmt.Sprintf("ICMP echo to up to 20 addresses around %s to find a live neighbour", addr.IPNet))
+	} else {
+		neighborIP, err = FindLiveNeighbor(addr.IPNet, 20, 2*time.Second, iface)
 	}
-
-	neighborIP, err := FindLiveNeighbor(addr.IPNet, 20, 2*time.Second, config.Interface)
 	if err != nil {
 		log.Println(err.Error())
 	} else {
-		log.Printf("[NETWORK] Neighbor reachable: %s", neighborIP)
+		log.Printf("[NETWORK][%s][%s] Neighbor reachable: %s", iface, result.Family, neighborIP)
+		result.Neighbor = neighborIP.String()
 	}
 
 	if config.IP != "" {
 		neighborIP = net.ParseIP(config.IP)
 	}
+	// Why there is nothing to take over, reported instead of a spoofing result
+	noTarget := ""
+	if neighborIP == nil {
+		noTarget = "no target"
+	} else if !inScope(neighborIP.String()) {
+		log.Printf("%s is outside the scope file, not spoofing it", neighborIP)
+		neighborIP = nil
+		noTarget = "skipped"
+	}
+
+	if config.SpoofMatrix {
+		allowed, untestable := 0, 0
+		for _, r := range RunSpoofMatrix(iface, family, addr, neighborIP) {
+			switch r.Status {
+			case spoofAllowed:
+				allowed++
+			case spoofUntestable:
+				untestable++
+			}
+		}
+		result.Spoofing = fmt.Sprintf("%d classes allowed", allowed)
+		if untestable > 0 {
+			result.Spoofing += fmt.Sprintf(", %d untestable", untestable)
+		}
+		if config.DryRun {
+			result.Spoofing = "planned"
+		}
+		return result, nil
+	}
+
+	if noTarget != "" {
+		result.Spoofing = noTarget
+		return result, nil
+	}
+
+	if config.Reflector != "" {
+		outcome, err := VerifySpoofedAddress(iface, addr.IPNet.IP, neighborIP, config.Reflector)
+		if err != nil {
+			log.Printf("Spoofing verification failed: %s", err.Error())
+			outcome = "failed"
+		}
+		result.Spoofing = outcome
+		return result, nil
+	}
 
 	// Attempt IP spoofing
-	if err := SpoofIP(config.Interface, addr.IPNet.IP, neighborIP, addr.IPNet.Mask); err != nil {
+	if err := SpoofIP(iface, addr.IPNet.IP, neighborIP, addr.IPNet.Mask); err != nil {
 		log.Printf("Spoofing failed: %s", err.Error())
+		switch {
+		case errors.Is(err, errSpoofBlocked):
+			result.Spoofing = "blocked"
+		case errors.Is(err, errSpoofTranslated):
+			result.Spoofing = "translated"
+		default:
+			result.Spoofing = "failed"
+		}
+	} else if config.DryRun {
+		result.Spoofing = "planned"
+	} else {
+		result.Spoofing = "spoofed " + neighborIP.String()
 	}
 
-	return nil
+	return result, nil
+}
+
+func printNetworkSummary(results []networkResult) {
+	if len(results) == 0 {
+		return
+	}
+	log.Println("\nNetwork test results per interface:")
+	log.Printf("  %-12s %-6s %-8s %-42s %-40s %-40s %s", "Interface", "Family", "Default", "Address", "External IP", "Neighbor", "Spoofing")
+	for _, r := range results {
+		log.Printf("  %-12s %-6s %-8t %-42s %-40s %-40s %s", r.Interface, r.Family, r.DefaultRoute, r.Address, r.ExternalIP, r.Neighbor, r.Spoofing)
+	}
 }
 
 // generateNeighborIPs finds neighboring IP addresses or network blocks
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const pciSysfs = "/sys/bus/pci/devices"
+
+// pciDevice is one function under /sys/bus/pci/devices
+type pciDevice struct {
+	Slot      string
+	Vendor    uint16
+	Device    uint16
+	SubVendor uint16
+	SubDevice uint16
+	Class     uint32 // class, subclass and programming interface
+	Driver    string
+}
+
+func (d pciDevice) ID() uint32 {
+	return uint32(d.Vendor)<<16 | uint32(d.Device)
+}
+
+func (d pciDevice) String() string {
+	name := pciDeviceNames[d.ID()]
+	if name == "" {
+		name = pciClassName(d.Class)
+	}
+	vendor := pciVendors[d.Vendor]
+	if vendor == "" {
+		vendor = "unknown vendor"
+	}
+	return fmt.Sprintf("%s %04x:%04x %s (%s)", d.Slot, d.Vendor, d.Device, name, vendor)
+}
+
+func pciID(vendor, device uint16) uint32 {
+	return uint32(vendor)<<16 | uint32(device)
+}
+
+var pciVendors = map[uint16]string{
+	0x1af4: "Red Hat (virtio)",
+	0x1b36: "Red Hat (QEMU)",
+	0x1234: "QEMU (Bochs)",
+	0x15ad: "VMware",
+	0x5853: "XenSource",
+	0xfffd: "XenSource",
+	0x1414: "Microsoft (Hyper-V)",
+	0x80ee: "VirtualBox",
+	0x1d0f: "Amazon",
+	0x1ae0: "Google",
+	0x8086: "Intel",
+	0x10ec: "Realtek",
+	0x1022: "AMD",
+	0x1013: "Cirrus Logic",
+	0x1274: "Ensoniq",
+	0x1002: "AMD/ATI",
+	0x10de: "NVIDIA",
+	0x15b3: "Mellanox",
+	0x14e4: "Broadcom",
+	0x1077: "QLogic",
+	0x1924: "Solarflare",
+	0x1425: "Chelsio",
+	0x144d: "Samsung",
+}
+
+// Vendors whose devices only exist as emulated or paravirtual hardware, and the hypervisor they identify
+var pciHypervisorVendors = map[uint16]string{
+	0x1af4: "kvm",
+	0x1b36: "kvm",
+	0x15ad: "vmware",
+	0x5853: "xen",
+	0xfffd: "xen",
+	0x1414: "hyperv",
+	0x1234: "kvm",
+	0x80ee: "virtualbox",
+}
+
+var pciDeviceNames = map[uint32]string{
+	// virtio, legacy (0x1000-0x103f) and modern (0x1040+) IDs
+	pciID(0x1af4, 0x1000): "virtio-net",
+	pciID(0x1af4, 0x1001): "virtio-blk",
+	pciID(0x1af4, 0x1002): "virtio-balloon",
+	pciID(0x1af4, 0x1003): "virtio-console",
+	pciID(0x1af4, 0x1004): "virtio-scsi",
+	pciID(0x1af4, 0x1005): "virtio-rng",
+	pciID(0x1af4, 0x1009): "virtio-9p",
+	pciID(0x1af4, 0x1041): "virtio-net",
+	pciID(0x1af4, 0x1042): "virtio-blk",
+	pciID(0x1af4, 0x1043): "virtio-console",
+	pciID(0x1af4, 0x1044): "virtio-rng",
+	pciID(0x1af4, 0x1045): "virtio-balloon",
+	pciID(0x1af4, 0x1048): "virtio-scsi",
+	pciID(0x1af4, 0x1049): "virtio-9p",
+	pciID(0x1af4, 0x1050): "virtio-gpu",
+	pciID(0x1af4, 0x1052): "virtio-input",
+	pciID(0x1af4, 0x1053): "virtio-vsock",
+	pciID(0x1af4, 0x1058): "virtio-mem",
+	pciID(0x1af4, 0x105a): "virtio-fs",
+	pciID(0x1af4, 0x1110): "ivshmem",
+	// QEMU
+	pciID(0x1b36, 0x0001): "PCI-PCI bridge",
+	pciID(0x1b36, 0x0002): "PCI 16550 serial",
+	pciID(0x1b36, 0x0005): "PCI test device",
+	pciID(0x1b36, 0x0008): "PCIe host bridge",
+	pciID(0x1b36, 0x000c): "PCIe root port",
+	pciID(0x1b36, 0x000d): "xHCI USB controller",
+	pciID(0x1b36, 0x0010): "NVMe controller",
+	pciID(0x1b36, 0x0011): "pvpanic",
+	pciID(0x1b36, 0x0100): "QXL display",
+	pciID(0x1234, 0x1111): "Bochs/std VGA",
+	// Intel chipsets and devices emulated by QEMU, VirtualBox and Hyper-V gen1
+	pciID(0x8086, 0x1237): "440FX host bridge",
+	pciID(0x8086, 0x7000): "PIIX3 ISA bridge",
+	pciID(0x8086, 0x7010): "PIIX3 IDE",
+	pciID(0x8086, 0x7020): "PIIX3 UHCI USB",
+	pciID(0x8086, 0x7110): "PIIX4 ISA bridge",
+	pciID(0x8086, 0x7111): "PIIX4 IDE",
+	pciID(0x8086, 0x7113): "PIIX4 ACPI",
+	pciID(0x8086, 0x7190): "440BX host bridge",
+	pciID(0x8086, 0x7192): "440BX host bridge (AGP disabled)",
+	pciID(0x8086, 0x29c0): "Q35 host bridge",
+	pciID(0x8086, 0x2918): "ICH9 LPC bridge",
+	pciID(0x8086, 0x2922): "ICH9 AHCI",
+	pciID(0x8086, 0x2930): "ICH9 SMBus",
+	pciID(0x8086, 0x2934): "ICH9 UHCI USB",
+	pciID(0x8086, 0x293a): "ICH9 EHCI USB",
+	pciID(0x8086, 0x293e): "ICH9 HD Audio",
+	pciID(0x8086, 0x2668): "ICH6 HD Audio",
+	pciID(0x8086, 0x2415): "ICH AC97 audio",
+	pciID(0x8086, 0x24cd): "ICH4 EHCI USB",
+	pciID(0x8086, 0x25ab): "6300ESB watchdog",
+	pciID(0x8086, 0x100e): "82540EM e1000",
+	pciID(0x8086, 0x100f): "82545EM e1000",
+	pciID(0x8086, 0x10d3): "82574L e1000e",
+	pciID(0x10ec, 0x8029): "RTL-8029 NE2000",
+	pciID(0x10ec, 0x8139): "RTL-8139",
+	pciID(0x1022, 0x2000): "PCnet-PCI II",
+	pciID(0x1013, 0x00b8): "Cirrus GD 5446 VGA",
+	pciID(0x1274, 0x5000): "ES1370 AudioPCI",
+	// VMware
+	pciID(0x15ad, 0x0405): "SVGA II",
+	pciID(0x15ad, 0x0740): "VMCI",
+	pciID(0x15ad, 0x0770): "EHCI USB",
+	pciID(0x15ad, 0x0774): "UHCI USB",
+	pciID(0x15ad, 0x0778): "xHCI USB",
+	pciID(0x15ad, 0x0779): "xHCI USB",
+	pciID(0x15ad, 0x0790): "PCI bridge",
+	pciID(0x15ad, 0x07a0): "PCIe root port",
+	pciID(0x15ad, 0x07b0): "VMXNET3",
+	pciID(0x15ad, 0x07c0): "PVSCSI",
+	pciID(0x15ad, 0x07e0): "SATA AHCI",
+	pciID(0x15ad, 0x07f0): "NVMe",
+	pciID(0x15ad, 0x1977): "HD Audio",
+	// Xen, Hyper-V and cloud
+	pciID(0x5853, 0x0001): "Xen platform device",
+	pciID(0x5853, 0x0002): "Xen platform device",
+	pciID(0xfffd, 0x0101): "Xen platform device",
+	pciID(0x1414, 0x5353): "Hyper-V VGA",
+	pciID(0x80ee, 0xbeef): "VirtualBox VGA",
+	pciID(0x80ee, 0xcafe): "VirtualBox guest service",
+	pciID(0x1d0f, 0x8061): "EBS NVMe",
+	pciID(0x1d0f, 0xec20): "ENA",
+	pciID(0x1d0f, 0xefa0): "EFA",
+	pciID(0x1d0f, 0x1111): "EC2 VGA",
+	pciID(0x1ae0, 0x0042): "gVNIC",
+}
+
+// Emulated devices whose device models have a history of guest-to-host vulnerabilities
+var pciRiskyDevices = map[uint32]string{
+	pciID(0x10ec, 0x8029): "NE2000 emulation, receive path overflows (CVE-2015-5279)",
+	pciID(0x10ec, 0x8139): "RTL8139 emulation, host memory disclosure (CVE-2015-5165)",
+	pciID(0x1022, 0x2000): "PCnet emulation, heap overflows (CVE-2015-7504, CVE-2015-7512)",
+	pciID(0x8086, 0x100e): "e1000 emulation, repeated DoS and overflow fixes",
+	pciID(0x8086, 0x100f): "e1000 emulation, repeated DoS and overflow fixes",
+	pciID(0x8086, 0x2415): "AC97 audio emulation",
+	pciID(0x1274, 0x5000): "ES1370 audio emulation",
+	pciID(0x8086, 0x2668): "Intel HDA emulation, DMA reentrancy (CVE-2021-3611)",
+	pciID(0x8086, 0x293e): "Intel HDA emulation, DMA reentrancy (CVE-2021-3611)",
+	pciID(0x8086, 0x24cd): "EHCI emulation, use-after-free and overflow history",
+	pciID(0x8086, 0x293a): "EHCI emulation, use-after-free and overflow history",
+	pciID(0x8086, 0x7020): "UHCI emulation",
+	pciID(0x8086, 0x2934): "UHCI emulation",
+	pciID(0x8086, 0x7010): "IDE/ATAPI emulation",
+	pciID(0x8086, 0x7111): "IDE/ATAPI emulation",
+	pciID(0x1013, 0x00b8): "Cirrus VGA emulation, bitblt overflows (CVE-2017-2615), deprecated upstream",
+	pciID(0x1b36, 0x0100): "QXL/SPICE display emulation",
+	pciID(0x15ad, 0x0405): "VMware SVGA, the most common target of VMware guest-to-host escapes",
+	pciID(0x15ad, 0x0770): "VMware EHCI, used in guest-to-host escapes",
+	pciID(0x15ad, 0x0774): "VMware UHCI, used in guest-to-host escapes",
+	pciID(0x15ad, 0x1977): "VMware HD Audio",
+	pciID(0x1af4, 0x1110): "ivshmem shares host memory with other guests or host processes",
+}
+
+// pciClassName describes the class code of devices missing from the ID table
+func pciClassName(class uint32) string {
+	switch class >> 16 {
+	case 0x01:
+		return "storage controller"
+	case 0x02:
+		return "network controller"
+	case 0x03:
+		return "display controller"
+	case 0x04:
+		return "multimedia controller"
+	case 0x06:
+		return "bridge"
+	case 0x0c:
+		return "serial bus controller"
+	case 0x12:
+		return "processing accelerator"
+	}
+	return fmt.Sprintf("class %06x", class)
+}
+
+func readSysfsHex(path string) uint32 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), 16, 32)
+	return uint32(value)
+}
+
+// readPCIDevices lists the PCI functions visible in sysfs
+func readPCIDevices() []pciDevice {
+	entries, err := os.ReadDir(pciSysfs)
+	if err != nil {
+		return nil
+	}
+	var devices []pciDevice
+	for _, entry := range entries {
+		dir := filepath.Join(pciSysfs, entry.Name())
+		dev := pciDevice{
+			Slot:      entry.Name(),
+			Vendor:    uint16(readSysfsHex(dir + "/vendor")),
+			Device:    uint16(readSysfsHex(dir + "/device")),
+			SubVendor: uint16(readSysfsHex(dir + "/subsystem_vendor")),
+			SubDevice: uint16(readSysfsHex(dir + "/subsystem_device")),
+			Class:     readSysfsHex(dir + "/class"),
+		}
+		if driver, err := os.Readlink(dir + "/driver"); err == nil {
+			dev.Driver = filepath.Base(driver)
+		}
+		devices = append(devices, dev)
+	}
+	return devices
+}
+
+// pciHypervisor returns the hypervisor identified by paravirtual PCI devices. Xen HVM guests also
+// get QEMU emulated devices, so the Xen platform device wins.
+func pciHypervisor() (string, pciDevice) {
+	var found string
+	var evidence pciDevice
+	for _, dev := range readPCIDevices() {
+		hv, ok := pciHypervisorVendors[dev.Vendor]
+		if !ok {
+			continue
+		}
+		if hv == "xen" {
+			return hv, dev
+		}
+		if found == "" {
+			found, evidence = hv, dev
+		}
+	}
+	return found, evidence
+}
+
+// isEmulatedPCI reports whether a device is provided by the hypervisor rather than real hardware
+func isEmulatedPCI(dev pciDevice) bool {
+	if _, ok := pciHypervisorVendors[dev.Vendor]; ok {
+		return true
+	}
+	if _, ok := pciDeviceNames[dev.ID()]; ok {
+		return true
+	}
+	// Emulated devices carry the hypervisor's subsystem vendor (eg. QEMU's 1af4:1100)
+	_, ok := pciHypervisorVendors[dev.SubVendor]
+	return ok
+}
+
+// hasFloppyController looks for an ISA floppy controller (PNP0700), the device behind VENOM
+func hasFloppyController() bool {
+	ids, _ := filepath.Glob("/sys/bus/pnp/devices/*/id")
+	for _, id := range ids {
+		if data, err := os.ReadFile(id); err == nil && strings.Contains(string(data), "PNP0700") {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPCIDevices lists the PCI devices of this VM, flags emulated devices with a history of
+// escapes and real hardware passed through from the host
+func CheckPCIDevices() bool {
+	devices := readPCIDevices()
+	if len(devices) == 0 {
+		log.Println("[VM][PCI] No PCI devices visible")
+		return true
+	}
+
+	passed := true
+	for _, dev := range devices {
+		driver := dev.Driver
+		if driver == "" {
+			driver = "no driver"
+		}
+		log.Printf("[VM][PCI] %s [%s]", dev, driver)
+
+		if reason, ok := pciRiskyDevices[dev.ID()]; ok {
+			log.Printf("[VM][PCI] WARNING: %s: %s, remove the device", dev.Slot, reason)
+			passed = false
+			continue
+		}
+		if !isEmulatedPCI(dev) && dev.Class>>16 != 0x06 {
+			log.Printf("[VM][PCI] WARNING: %s looks like real hardware passed through from the host (%s)", dev.Slot, pciClassName(dev.Class))
+			passed = false
+		}
+	}
+
+	if hasFloppyController() {
+		log.Println("[VM][PCI] WARNING: floppy controller present, FDC emulation was behind VENOM (CVE-2015-3456), remove the device")
+		passed = false
+	}
+	return passed
+}
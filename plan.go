@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// plannedAction is an intrusive action that -dry-run reports instead of executing
+type plannedAction struct {
+	Kind   string `json:"kind"` // netlink, packets, http, mount, open, ioport or xenstore
+	Target string `json:"target"`
+	Detail string `json:"detail"`
+}
+
+var plan []plannedAction
+
+// planAction records an action for the dry-run plan
+func planAction(kind, target, detail string) {
+	plan = append(plan, plannedAction{kind, target, detail})
+	log.Printf("[PLAN][%s] %s: %s", kind, target, detail)
+}
+
+// dryRunSkip records the action and reports true when running with -dry-run, in which case
+// the caller must not execute it
+func dryRunSkip(kind, target, detail string) bool {
+	if !config.DryRun {
+		return false
+	}
+	planAction(kind, target, detail)
+	return true
+}
+
+// writePlanReport saves the plan to the report file in the requested output format
+func writePlanReport() error {
+	var content string
+	var ext string
+
+	switch config.OutputFormat {
+	case "json":
+		ext = ".json"
+		data, err := json.MarshalIndent(map[string]any{
+			"generated": time.Now().UTC().Format(time.RFC3339),
+			"dry_run":   true,
+			"plan":      plan,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		content = string(data) + "\n"
+	case "html":
+		ext = ".html"
+		var b strings.Builder
+		b.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Hostile dry-run plan</title></head><body>\n")
+		fmt.Fprintf(&b, "<h1>Hostile dry-run plan</h1>\n<p>Generated %s</p>\n", time.Now().UTC().Format(time.RFC3339))
+		b.WriteString("<table border=\"1\">\n<tr><th>#</th><th>Kind</th><th>Target</th><th>Action</th></tr>\n")
+		for i, action := range plan {
+			fmt.Fprintf(&b, "<tr><td>%d</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+				i+1, html.EscapeString(action.Kind), html.EscapeString(action.Target), html.EscapeString(action.Detail))
+		}
+		b.WriteString("</table>\n</body></html>\n")
+		content = b.String()
+	default:
+		ext = ".txt"
+		var b strings.Builder
+		fmt.Fprintf(&b, "Hostile dry-run plan (generated %s)\n\n", time.Now().UTC().Format(time.RFC3339))
+		for i, action := range plan {
+			fmt.Fprintf(&b, "%3d. [%s] %s: %s\n", i+1, action.Kind, action.Target, action.Detail)
+		}
+		content = b.String()
+	}
+
+	path := config.OutputFile + ext
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write plan to %s: %w", path, err)
+	}
+	log.Printf("Dry-run plan with %d actions written to %s", len(plan), path)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parsePorts parses a comma-separated list of ports and port ranges (eg. "22,80,5900-5999")
+func parsePorts(spec string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		low, high := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			low, high = part[:i], part[i+1:]
+		}
+
+		start, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", part)
+		}
+		end, err := strconv.Atoi(high)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", part)
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range: %s", part)
+		}
+
+		for port := start; port <= end; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+
+	return ports, nil
+}
+
+func tcpPortOpen(host string, port int, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// scanTCPPorts connects to every port concurrently and returns the open ones in ascending order.
+// The host may carry an IPv6 zone (eg. "fe80::1%eth0").
+func scanTCPPorts(host string, ports []int, timeout time.Duration) []int {
+	var open []int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 64)
+
+	for _, port := range ports {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(port int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if tcpPortOpen(host, port, timeout) {
+				mu.Lock()
+				open = append(open, port)
+				mu.Unlock()
+			}
+		}(port)
+	}
+	wg.Wait()
+
+	sort.Ints(open)
+	return open
+}
+
+// scanHostsTCP scans the same ports on many hosts concurrently and returns the open ports per host
+func scanHostsTCP(hosts []string, ports []int, timeout time.Duration) map[string][]int {
+	open := make(map[string][]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 256)
+
+	for _, host := range hosts {
+		for _, port := range ports {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(host string, port int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if tcpPortOpen(host, port, timeout) {
+					mu.Lock()
+					open[host] = append(open[host], port)
+					mu.Unlock()
+				}
+			}(host, port)
+		}
+	}
+	wg.Wait()
+
+	for host := range open {
+		sort.Ints(open[host])
+	}
+	return open
+}
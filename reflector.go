@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The reflector runs on a cooperating host outside the provider's network. Hostile asks it over
+// a control connection to open a TCP connection to a spoofed address, then watches whether that
+// connection reaches the VM:
+//
+//	reflector -> client: HELLO <nonce>\n
+//	client -> reflector: CONNECT <ip> <port> <token> <mac>\n
+//	reflector -> client: SEEN <control connection source>\n
+//	reflector -> <ip>:<port>: <token>\n
+//	reflector -> client: OK\n or ERR <reason>\n
+//
+// It also observes egress: after WATCH the client sends a UDP datagram carrying the token from a
+// spoofed source to the reflector's port, and the reflector reports the source it arrived from:
+//
+//	client -> reflector: WATCH <token> <mac>\n
+//	reflector -> client: SEEN <control connection source>\n
+//	reflector -> client: READY\n
+//	client -> reflector (udp): <token>
+//	reflector -> client: FROM <datagram source>\n or ERR timeout\n
+//
+// mac is hex(HMAC-SHA256(secret, "<nonce> <request without the mac>")), so only clients holding
+// the shared secret can use the reflector and a captured request can't be replayed. Targets are
+// limited to the control connection's own address and the prefixes passed with -allow-targets.
+
+const (
+	reflectorTimeout = 5 * time.Second
+	// Requests accepted per source address and minute
+	reflectorRateLimit = 20
+)
+
+// rateLimiter counts requests per key in fixed one minute windows
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Time
+	counts map[string]int
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.window) > time.Minute {
+		l.window = time.Now()
+		l.counts = make(map[string]int)
+	}
+	l.counts[key]++
+	return l.counts[key] <= l.limit
+}
+
+type reflectorServer struct {
+	secret  []byte
+	allowed []netip.Prefix
+	limiter *rateLimiter
+
+	mu      sync.Mutex
+	watches map[string]chan string
+}
+
+func reflectorMAC(secret []byte, nonce, request string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(nonce + " " + request))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RunReflector serves CONNECT requests until the process is killed
+func RunReflector(listen, secret string, allowed []netip.Prefix) error {
+	if secret == "" {
+		return fmt.Errorf("the reflector requires -secret")
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+	defer listener.Close()
+	packets, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s/udp: %w", listen, err)
+	}
+	defer packets.Close()
+	log.Printf("Reflector listening on %s, allowed targets: control connection source %v", listener.Addr(), allowed)
+
+	server := &reflectorServer{
+		secret:  []byte(secret),
+		allowed: allowed,
+		limiter: &rateLimiter{limit: reflectorRateLimit},
+		watches: make(map[string]chan string),
+	}
+	go server.serveDatagrams(packets)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.handleClient(conn)
+	}
+}
+
+// targetAllowed accepts the control connection's own address and the configured prefixes
+func (r *reflectorServer) targetAllowed(target, source net.IP) bool {
+	if target.Equal(source) {
+		return true
+	}
+	addr, ok := netip.AddrFromSlice(target)
+	if !ok {
+		return false
+	}
+	for _, prefix := range r.allowed {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// readRequest sends a fresh nonce and returns the fields of the authenticated request that follows
+func (r *reflectorServer) readRequest(conn net.Conn, reader *bufio.Reader) ([]string, error) {
+	nonce := hex.EncodeToString(randomBytes(16))
+	fmt.Fprintf(conn, "HELLO %s\n", nonce)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid request")
+	}
+	request, mac := strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1]
+	if !hmac.Equal([]byte(mac), []byte(reflectorMAC(r.secret, nonce, request))) {
+		return nil, fmt.Errorf("authentication failed")
+	}
+	return fields[:len(fields)-1], nil
+}
+
+func (r *reflectorServer) handleClient(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * reflectorTimeout))
+
+	source, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if !r.limiter.allow(source) {
+		fmt.Fprintf(conn, "ERR rate limit exceeded\n")
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	fields, err := r.readRequest(conn, reader)
+	if err != nil {
+		log.Printf("Rejected request from %s: %s", source, err.Error())
+		fmt.Fprintf(conn, "ERR %s\n", err.Error())
+		return
+	}
+	switch {
+	case len(fields) == 4 && fields[0] == "CONNECT":
+		r.handleConnect(conn, source, fields)
+	case len(fields) == 2 && fields[0] == "WATCH":
+		r.handleWatch(conn, source, fields[1])
+	default:
+		fmt.Fprintf(conn, "ERR invalid request\n")
+	}
+}
+
+func (r *reflectorServer) handleConnect(conn net.Conn, source string, fields []string) {
+	ip := net.ParseIP(fields[1])
+	port, err := strconv.Atoi(fields[2])
+	if ip == nil || err != nil {
+		fmt.Fprintf(conn, "ERR invalid address\n")
+		return
+	}
+	if !r.targetAllowed(ip, net.ParseIP(source)) {
+		log.Printf("Refused to connect to %s for %s: target not allowed", ip, source)
+		fmt.Fprintf(conn, "ERR target %s not allowed\n", ip)
+		return
+	}
+
+	fmt.Fprintf(conn, "SEEN %s\n", source)
+	log.Printf("Connecting to %s:%d for %s", ip, port, source)
+
+	target, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), reflectorTimeout)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %s\n", err.Error())
+		return
+	}
+	defer target.Close()
+	fmt.Fprintf(target, "%s\n", fields[3])
+	fmt.Fprintf(conn, "OK\n")
+}
+
+// handleWatch waits for a datagram carrying token and reports the source it was sent from
+func (r *reflectorServer) handleWatch(conn net.Conn, source, token string) {
+	arrived := make(chan string, 1)
+	r.mu.Lock()
+	if _, exists := r.watches[token]; exists {
+		r.mu.Unlock()
+		fmt.Fprintf(conn, "ERR token in use\n")
+		return
+	}
+	r.watches[token] = arrived
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.watches, token)
+		r.mu.Unlock()
+	}()
+
+	fmt.Fprintf(conn, "SEEN %s\nREADY\n", source)
+	select {
+	case from := <-arrived:
+		log.Printf("Datagram for %s arrived from %s", source, from)
+		fmt.Fprintf(conn, "FROM %s\n", from)
+	case <-time.After(reflectorTimeout):
+		fmt.Fprintf(conn, "ERR timeout\n")
+	}
+}
+
+// serveDatagrams hands datagrams carrying a watched token to the waiting control connection
+func (r *reflectorServer) serveDatagrams(packets net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := packets.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		arrived, ok := r.watches[strings.TrimSpace(string(buf[:n]))]
+		r.mu.Unlock()
+		if !ok {
+			continue
+		}
+		host, _, _ := net.SplitHostPort(from.String())
+		select {
+		case arrived <- host:
+		default:
+		}
+	}
+}
+
+// reflectorSession is an authenticated control connection to a reflector
+type reflectorSession struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nonce  string
+}
+
+// dialReflector opens a control connection from source and reads the reflector's nonce
+func dialReflector(reflector string, source net.IP) (*reflectorSession, error) {
+	dialer := net.Dialer{Timeout: reflectorTimeout, LocalAddr: &net.TCPAddr{IP: source}}
+	conn, err := dialer.Dial("tcp", reflector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach reflector: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(3 * reflectorTimeout))
+	session := &reflectorSession{conn: conn, reader: bufio.NewReader(conn)}
+
+	line, err := session.reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reflector closed the connection: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "HELLO ") {
+		conn.Close()
+		return nil, fmt.Errorf("reflector: %s", strings.TrimPrefix(line, "ERR "))
+	}
+	session.nonce = strings.TrimPrefix(line, "HELLO ")
+	return session, nil
+}
+
+// send writes a request signed with the shared secret
+func (s *reflectorSession) send(fields ...string) {
+	request := strings.Join(fields, " ")
+	fmt.Fprintf(s.conn, "%s %s\n", request, reflectorMAC([]byte(config.ReflectorSecret), s.nonce, request))
+}
+
+func (s *reflectorSession) Close() error {
+	return s.conn.Close()
+}
+
+// requestReflection asks the reflector to connect to ip:port from our original address
+func requestReflection(reflector string, source, ip net.IP, port int, token string) (string, error) {
+	session, err := dialReflector(reflector, source)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	session.send("CONNECT", ip.String(), strconv.Itoa(port), token)
+	var seen string
+	for {
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return seen, fmt.Errorf("reflector closed the connection: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "SEEN "):
+			seen = strings.TrimPrefix(line, "SEEN ")
+		case line == "OK":
+			return seen, nil
+		case strings.HasPrefix(line, "ERR "):
+			return seen, fmt.Errorf("reflector: %s", strings.TrimPrefix(line, "ERR "))
+		}
+	}
+}
+
+// watchSpoofedSource asks the reflector, over a control connection from source, to watch for a
+// datagram sent from spoofed. It returns the source address the datagram arrived from, or "" if
+// none arrived.
+func watchSpoofedSource(reflector string, source, spoofed net.IP) (string, error) {
+	session, err := dialReflector(reflector, source)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	token := hex.EncodeToString(randomBytes(8))
+	session.send("WATCH", token)
+	for ready := false; !ready; {
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("reflector closed the connection: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "READY":
+			ready = true
+		case strings.HasPrefix(line, "ERR "):
+			return "", fmt.Errorf("reflector: %s", strings.TrimPrefix(line, "ERR "))
+		}
+	}
+
+	remote := session.conn.RemoteAddr().(*net.TCPAddr)
+	datagram, err := net.DialUDP("udp", &net.UDPAddr{IP: spoofed}, &net.UDPAddr{IP: remote.IP, Port: remote.Port})
+	if err != nil {
+		return "", fmt.Errorf("failed to send from %s: %w", spoofed, err)
+	}
+	defer datagram.Close()
+	// A few copies in case one is lost on the way
+	for i := 0; i < 3; i++ {
+		datagram.Write([]byte(token))
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	line, err := session.reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("reflector closed the connection: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "FROM "):
+		return strings.TrimPrefix(line, "FROM "), nil
+	case line == "ERR timeout":
+		return "", nil
+	}
+	return "", fmt.Errorf("reflector: %s", strings.TrimPrefix(line, "ERR "))
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// sniffTCPSyn watches the interface at the link layer for TCP segments to ip:port. This catches
+// inbound packets even when our own replies are filtered and the handshake never completes.
+func sniffTCPSyn(iface string, ip net.IP, port int, done <-chan struct{}) (bool, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return false, err
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return false, err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: ifi.Index}); err != nil {
+		return false, err
+	}
+	tv := syscall.NsecToTimeval(int64(200 * time.Millisecond))
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+
+	buf := make([]byte, 2048)
+	for {
+		select {
+		case <-done:
+			return false, nil
+		default:
+		}
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil || n < 14 {
+			continue
+		}
+		frame := buf[:n]
+		var dst net.IP
+		var transport []byte
+		switch binary.BigEndian.Uint16(frame[12:14]) {
+		case syscall.ETH_P_IP:
+			if n < 34 || frame[23] != syscall.IPPROTO_TCP {
+				continue
+			}
+			ihl := int(frame[14]&0x0f) * 4
+			dst = net.IP(frame[30:34])
+			transport = frame[14+ihl:]
+		case syscall.ETH_P_IPV6:
+			if n < 54 || frame[20] != syscall.IPPROTO_TCP {
+				continue
+			}
+			dst = net.IP(frame[38:54])
+			transport = frame[54:]
+		default:
+			continue
+		}
+		if len(transport) >= 4 && dst.Equal(ip) && int(binary.BigEndian.Uint16(transport[2:4])) == port {
+			return true, nil
+		}
+	}
+}
+
+// VerifySpoofedAddress takes over spoofedIP next to our own address and checks both directions:
+// egress (a request to ip.me sourced from spoofedIP gets an answer) and inbound (a connection the
+// reflector opens to spoofedIP arrives on our interface)
+func VerifySpoofedAddress(iface string, originalIP, spoofedIP net.IP, reflector string) (string, error) {
+	if config.DryRun {
+		AddHostIP(iface, spoofedIP)
+		planAction("http", "http://ip.me", fmt.Sprintf("GET with source %s", spoofedIP))
+		planAction("packets", reflector, fmt.Sprintf("ask the reflector (from %s) to connect to a listener on %s, capture packets on %s", originalIP, spoofedIP, iface))
+		DeleteHostIP(iface, spoofedIP)
+		return "planned", nil
+	}
+
+	if err := AddHostIP(iface, spoofedIP); err != nil {
+		return "", fmt.Errorf("failed to add spoofed IP: %w", err)
+	}
+	defer DeleteHostIP(iface, spoofedIP)
+	time.Sleep(1 * time.Second)
+
+	log.Printf("Testing egress from %s...", spoofedIP)
+	egress := false
+	if detectedIP, err := GetExternalIPFromSource(spoofedIP, 10*time.Second); err != nil {
+		log.Printf("Egress from spoofed address failed: %s", err.Error())
+	} else {
+		log.Printf("Egress from spoofed address answered, seen as %s", detectedIP)
+		egress = true
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(spoofedIP.String(), "0"))
+	if err != nil {
+		return "", fmt.Errorf("failed to listen on spoofed IP: %w", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	token := hex.EncodeToString(randomBytes(8))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	var sniffed bool
+	var sniffErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sniffed, sniffErr = sniffTCPSyn(iface, spoofedIP, port, done)
+	}()
+
+	received := make(chan bool, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- false
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(reflectorTimeout))
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- strings.TrimSpace(line) == token
+	}()
+
+	log.Printf("Asking reflector %s to connect to %s:%d...", reflector, spoofedIP, port)
+	seen, reflectErr := requestReflection(reflector, originalIP, spoofedIP, port, token)
+	if seen != "" {
+		log.Printf("Reflector sees our control connection from %s", seen)
+	}
+	if reflectErr != nil {
+		log.Printf("Reflection failed: %s", reflectErr.Error())
+	}
+
+	inbound := false
+	select {
+	case inbound = <-received:
+	case <-time.After(reflectorTimeout):
+	}
+	listener.Close()
+	close(done)
+	wg.Wait()
+	if sniffErr != nil {
+		log.Printf("Failed to watch the interface for inbound packets: %s", sniffErr.Error())
+	}
+
+	var outcome string
+	switch {
+	case inbound && egress:
+		outcome = "full address takeover"
+		log.Printf("[NETWORK][%s] Full address takeover: %s is usable for both egress and inbound traffic", iface, spoofedIP)
+	case inbound:
+		outcome = "inbound only"
+		log.Printf("[NETWORK][%s] Inbound traffic to %s is delivered to us but egress from it is blocked", iface, spoofedIP)
+	case sniffed:
+		outcome = "inbound packets only"
+		log.Printf("[NETWORK][%s] Inbound packets to %s reach our interface but the connection did not complete (our replies are filtered)", iface, spoofedIP)
+	case egress:
+		outcome = "egress spoofing only"
+		log.Printf("[NETWORK][%s] Egress spoofing only: %s can send traffic but inbound connections are not delivered to us", iface, spoofedIP)
+	default:
+		outcome = "blocked"
+		log.Printf("Spoofed address %s is neither usable for egress nor inbound traffic", spoofedIP)
+	}
+	return outcome, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secretRule matches a credential in a single line. Group selects the secret itself within the
+// match (0 for the whole match). MinEntropy discards repetitive values like "xxxxxxxx", and rules
+// that set it also skip the well-known placeholders in placeholderSecrets, which entropy can't
+// tell apart from real passwords.
+type secretRule struct {
+	Name       string
+	Regex      *regexp.Regexp
+	Group      int
+	MinEntropy float64
+}
+
+var secretRules = []secretRule{
+	{"private-key", regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|ENCRYPTED|PGP) )?PRIVATE KEY( BLOCK)?-----`), 0, 0},
+	{"kickstart-rootpw", regexp.MustCompile(`^\s*rootpw\s+(?:--\S+\s+)*(\S+)`), 1, 0},
+	{"kickstart-user-password", regexp.MustCompile(`^\s*user\s.*--password[= ](\S+)`), 1, 0},
+	{"preseed-password", regexp.MustCompile(`passwd/(?:root-password|user-password)(?:-again|-crypted)?\s+password\s+(\S+)`), 1, 0},
+	{"password-hash", passwordHashRegex, 0, 0},
+	{"password-assignment", regexp.MustCompile(`(?i)\b(?:root_?)?(?:pass(?:word|wd)?|pwd)\b["']?\s*[:=]\s*["']?([^\s"',;]{4,})`), 1, 2.5},
+	{"aws-access-key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`), 0, 0},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`), 0, 0},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`), 0, 0},
+	{"bearer-token", regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]{20,}=*)`), 1, 3.5},
+	{"api-token", regexp.MustCompile(`(?i)\b(?:api[_-]?key|api[_-]?token|access[_-]?token|secret[_-]?key|client[_-]?secret|auth[_-]?token|token)\b["']?\s*[:=]\s*["']?([A-Za-z0-9\-_./+=]{16,})`), 1, 3.5},
+}
+
+// Example and default values found in templates and documentation
+var placeholderSecrets = map[string]bool{
+	"changeme": true, "change_me": true, "changeit": true, "password": true, "passw0rd": true,
+	"hunter2": true, "secret": true, "example": true, "redacted": true, "placeholder": true,
+	"your_password": true, "yourpassword": true, "mypassword": true, "<password>": true,
+	"letmein": true, "admin": true, "root": true, "none": true, "null": true, "test": true,
+}
+
+// Provisioning leftovers found regardless of platform. Directories are walked recursively.
+var secretScanPaths = []string{
+	"/proc/cmdline",
+	"/root/anaconda-ks.cfg",
+	"/root/original-ks.cfg",
+	"/root/ks.cfg",
+	"/root/*.log",
+	"/root/*.cfg",
+	"/root/*.sh",
+	"/preseed.cfg",
+	"/var/log/installer",
+	"/var/log/anaconda",
+	"/etc/sysconfig/network-scripts/ifcfg-*",
+	"/etc/network/interfaces",
+	"/etc/network/interfaces.d/*",
+	"/etc/netplan/*.yaml",
+	"/var/lib/cloud/seed",
+}
+
+// Paths written by a specific platform's provisioning
+var platformSecretScanPaths = map[string][]string{
+	"solusvm": {
+		"/etc/sysconfig/network",
+		"/etc/hosts",
+		"/etc/hostname",
+		"/root/solusvm*",
+	},
+	"openstack": {
+		"/var/lib/cloud/data/*",
+	},
+	"proxmox": {
+		"/var/lib/cloud/seed/nocloud*",
+	},
+}
+
+const secretScanMaxSize = 4 << 20
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	length := float64(len([]rune(s)))
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// mountPointOf returns where a block device (or a symlink to one) is mounted
+func mountPointOf(device string) string {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if source, err := filepath.EvalSymlinks(fields[0]); err == nil && source == resolved {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// secretScanFiles expands the generic and platform paths, plus any mounted config drive, into files
+func secretScanFiles(platform string) []string {
+	patterns := append([]string{}, secretScanPaths...)
+	patterns = append(patterns, platformSecretScanPaths[platform]...)
+	for _, drive := range configDrives {
+		if mount := mountPointOf("/dev/disk/by-label/" + drive.Label); mount != "" {
+			patterns = append(patterns, mount)
+		}
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() || seen[path] {
+					return nil
+				}
+				if d.Type().IsRegular() || path == "/proc/cmdline" {
+					seen[path] = true
+					files = append(files, path)
+				}
+				return nil
+			})
+		}
+	}
+	return files
+}
+
+// scanFileForSecrets scans a text file for credentials, skipping large and binary files
+func scanFileForSecrets(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err != nil || info.Size() > secretScanMaxSize {
+		return 0
+	}
+	return scanReaderForSecrets(path, file)
+}
+
+// scanReaderForSecrets applies every rule to each line and logs redacted matches as source:line
+func scanReaderForSecrets(source string, r io.Reader) int {
+	reader := bufio.NewReader(r)
+	if head, _ := reader.Peek(512); bytes.IndexByte(head, 0) >= 0 {
+		return 0
+	}
+
+	findings := 0
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		type match struct{ rule, secret string }
+		var matches []match
+		reported := make(map[string]bool)
+		for _, rule := range secretRules {
+			for _, m := range rule.Regex.FindAllStringSubmatch(line, -1) {
+				secret := m[rule.Group]
+				if secret == "" || reported[secret] || shannonEntropy(secret) < rule.MinEntropy {
+					continue
+				}
+				if rule.MinEntropy > 0 && placeholderSecrets[strings.ToLower(secret)] {
+					continue
+				}
+				reported[secret] = true
+				matches = append(matches, match{rule.Name, secret})
+			}
+		}
+
+		// Redact every secret on the line before showing it for any rule
+		excerpt := strings.TrimSpace(line)
+		for _, m := range matches {
+			if m.rule != "private-key" {
+				excerpt = strings.ReplaceAll(excerpt, m.secret, redactSecret(m.secret))
+			}
+		}
+		for _, m := range matches {
+			log.Printf("[Secrets][%s] WARNING: %s:%d %s", m.rule, source, lineNo, truncate(excerpt, 120))
+			findings++
+		}
+	}
+	return findings
+}
+
+// CheckProvisioningSecrets scans installer leftovers, generated network files, config drives and
+// the kernel command line for credentials the provider left behind
+func CheckProvisioningSecrets(platform string) bool {
+	files := secretScanFiles(platform)
+	findings := 0
+	for _, file := range files {
+		findings += scanFileForSecrets(file)
+	}
+
+	if findings > 0 {
+		log.Printf("[Secrets][Summary] Found %d possible credentials in %d provisioning files", findings, len(files))
+		return false
+	}
+	log.Printf("[Secrets][Summary] No credentials found in %d provisioning files", len(files))
+	return true
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const smbiosTablePath = "/sys/firmware/dmi/tables/DMI"
+
+// SMBIOS structure types parsed here (DSP0134)
+const (
+	smbiosBIOS          = 0
+	smbiosSystem        = 1
+	smbiosBaseboard     = 2
+	smbiosChassis       = 3
+	smbiosProcessor     = 4
+	smbiosOEMStrings    = 11
+	smbiosMemoryDevice  = 17
+	smbiosIPMIDevice    = 38
+	smbiosOnboardDevice = 41
+	smbiosEndOfTable    = 127
+)
+
+// smbiosStructure is one table entry: the formatted area (header included) and its string set
+type smbiosStructure struct {
+	Type    byte
+	Handle  uint16
+	Data    []byte
+	Strings []string
+}
+
+func (s smbiosStructure) byteAt(offset int) byte {
+	if offset >= len(s.Data) {
+		return 0
+	}
+	return s.Data[offset]
+}
+
+func (s smbiosStructure) word(offset int) uint16 {
+	if offset+2 > len(s.Data) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(s.Data[offset:])
+}
+
+// str resolves the string referenced by the byte at offset, strings are numbered from 1
+func (s smbiosStructure) str(offset int) string {
+	index := int(s.byteAt(offset))
+	if index == 0 || index > len(s.Strings) {
+		return ""
+	}
+	return strings.TrimSpace(s.Strings[index-1])
+}
+
+// parseSMBIOS splits a raw structure table into its structures
+func parseSMBIOS(table []byte) ([]smbiosStructure, error) {
+	var structures []smbiosStructure
+	for len(table) >= 4 {
+		length := int(table[1])
+		if length < 4 || length > len(table) {
+			return structures, fmt.Errorf("structure at handle %#x has invalid length %d", binary.LittleEndian.Uint16(table[2:]), length)
+		}
+		s := smbiosStructure{
+			Type:   table[0],
+			Handle: binary.LittleEndian.Uint16(table[2:]),
+			Data:   table[:length],
+		}
+
+		// The string set follows the formatted area and ends with two NULs
+		end := bytes.Index(table[length:], []byte{0, 0})
+		if end < 0 {
+			return structures, fmt.Errorf("unterminated string set at handle %#x", s.Handle)
+		}
+		for _, str := range bytes.Split(table[length:length+end], []byte{0}) {
+			if len(str) > 0 {
+				s.Strings = append(s.Strings, string(str))
+			}
+		}
+		structures = append(structures, s)
+		table = table[length+end+2:]
+
+		if s.Type == smbiosEndOfTable {
+			break
+		}
+	}
+	return structures, nil
+}
+
+var (
+	smbiosOnce       sync.Once
+	smbiosStructures []smbiosStructure
+	smbiosErr        error
+)
+
+// readSMBIOS parses the raw SMBIOS table once, the detectors and the checks share it
+func readSMBIOS() ([]smbiosStructure, error) {
+	smbiosOnce.Do(func() {
+		table, err := os.ReadFile(smbiosTablePath)
+		if err != nil {
+			smbiosErr = err
+			return
+		}
+		smbiosStructures, smbiosErr = parseSMBIOS(table)
+		if len(smbiosStructures) > 0 {
+			smbiosErr = nil
+		}
+	})
+	return smbiosStructures, smbiosErr
+}
+
+func smbiosOfType(structures []smbiosStructure, t byte) []smbiosStructure {
+	var found []smbiosStructure
+	for _, s := range structures {
+		if s.Type == t {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// smbiosVirtualMachine reports the "SMBIOS describes a virtual machine" BIOS characteristic (bit 4 of extension byte 2)
+func smbiosVirtualMachine(structures []smbiosStructure) bool {
+	for _, bios := range smbiosOfType(structures, smbiosBIOS) {
+		if len(bios.Data) > 0x13 && bios.Data[0x13]&0x10 != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// smbiosHypervisor identifies the hypervisor from the BIOS and system strings
+func smbiosHypervisor() (string, string) {
+	structures, err := readSMBIOS()
+	if err != nil {
+		return "", ""
+	}
+	for _, s := range structures {
+		var fields []string
+		switch s.Type {
+		case smbiosBIOS:
+			fields = []string{s.str(4), s.str(5)}
+		case smbiosSystem:
+			fields = []string{s.str(4), s.str(5), s.str(6)}
+		default:
+			continue
+		}
+		value := strings.Join(fields, " ")
+		lower := strings.ToLower(value)
+		switch {
+		case strings.Contains(lower, "qemu"), strings.Contains(lower, "kvm"), strings.HasPrefix(lower, "pc-i440fx"), strings.Contains(lower, "pc-q35"):
+			return "kvm", value
+		case strings.Contains(lower, "vmware"):
+			return "vmware", value
+		case strings.Contains(lower, "xen"):
+			return "xen", value
+		case strings.Contains(lower, "microsoft corporation") && strings.Contains(lower, "virtual machine"):
+			return "hyperv", value
+		}
+	}
+	return "", ""
+}
+
+// Values firmware uses for unset fields
+var smbiosPlaceholders = []string{
+	"", "not specified", "not present", "to be filled by o.e.m.", "default string", "none", "unknown",
+	"n/a", "0", "0123456789", "system serial number", "chassis serial number", "base board serial number",
+	"not available", "no asset tag", "asset tag",
+}
+
+func isSMBIOSPlaceholder(value string) bool {
+	lower := strings.ToLower(strings.TrimSpace(value))
+	for _, placeholder := range smbiosPlaceholders {
+		if lower == placeholder {
+			return true
+		}
+	}
+	return strings.Trim(lower, "0f") == ""
+}
+
+// Manufacturers of physical servers and components, seen in a guest they describe the host
+var smbiosHardwareVendors = []string{
+	"dell", "hewlett", "hpe", "supermicro", "lenovo", "cisco", "fujitsu", "gigabyte", "asus", "asrock",
+	"quanta", "inspur", "huawei", "wiwynn", "tyan", "intel corporation", "samsung", "micron", "hynix",
+	"kingston", "crucial",
+}
+
+func isHardwareVendor(value string) bool {
+	lower := strings.ToLower(value)
+	for _, vendor := range smbiosHardwareVendors {
+		if strings.Contains(lower, vendor) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	qemuMachineRegex = regexp.MustCompile(`pc-(i440fx|q35)-(\d+\.\d+)(\+pve\d+)?`)
+	// systemd imports OEM strings of this form as service credentials (systemd.system-credentials(7))
+	systemdCredentialRegex = regexp.MustCompile(`^io\.systemd\.credential(\.binary)?:([^=]+)=(.*)$`)
+	hostnameRegex          = regexp.MustCompile(`(?i)\b[a-z0-9][a-z0-9-]*(\.[a-z0-9-]+)+\.[a-z]{2,}\b|\b(node|host|hv|pve|kvm|compute|hypervisor|esxi?)[-_]?\d+[a-z0-9-]*\b`)
+)
+
+// redactOEMString shows the key of key=value OEM strings and only a redacted value, OEM strings
+// are free-form and routinely carry secrets the rules don't know
+func redactOEMString(value string) string {
+	if key, secret, ok := strings.Cut(value, "="); ok {
+		return truncate(key, 80) + "=" + redactSecret(secret)
+	}
+	return redactSecret(value)
+}
+
+// Chassis types of physical enclosures (SMBIOS 7.4.1), QEMU reports "Other"
+var physicalChassisTypes = map[byte]string{
+	0x03: "Desktop", 0x04: "Low Profile Desktop", 0x06: "Mini Tower", 0x07: "Tower", 0x09: "Laptop",
+	0x0a: "Notebook", 0x11: "Main Server Chassis", 0x17: "Rack Mount Chassis", 0x18: "Sealed-case PC",
+	0x19: "Multi-system chassis", 0x1c: "Blade", 0x1d: "Blade Enclosure",
+}
+
+// analyzeSMBIOS reports the SMBIOS structures the host passes to this guest and what they leak
+func analyzeSMBIOS(structures []smbiosStructure) bool {
+	passed := true
+	warn := func(format string, args ...any) {
+		log.Printf("[VM][SMBIOS] WARNING: "+format, args...)
+		passed = false
+	}
+
+	if smbiosVirtualMachine(structures) {
+		log.Println("[VM][SMBIOS] BIOS characteristics mark this system as a virtual machine")
+	}
+
+	for _, s := range structures {
+		switch s.Type {
+		case smbiosBIOS:
+			vendor, version, date := s.str(4), s.str(5), s.str(8)
+			log.Printf("[VM][SMBIOS] BIOS: %s %s (%s)", vendor, version, date)
+			if lower := strings.ToLower(version); strings.Contains(lower, "qemu.org") || strings.Contains(lower, "debian") || strings.Contains(lower, "ubuntu") || strings.Contains(lower, ".el") {
+				warn("BIOS version %s reveals the host's firmware build", version)
+			}
+
+		case smbiosSystem:
+			manufacturer, product, version, serial := s.str(4), s.str(5), s.str(6), s.str(7)
+			log.Printf("[VM][SMBIOS] System: %s %s, version %s, serial %s, SKU %s, family %s", manufacturer, product, version, serial, s.str(0x19), s.str(0x1a))
+			if len(s.Data) >= 0x18 {
+				uuid := s.Data[8:0x18]
+				log.Printf("[VM][SMBIOS] System UUID: %08x-%04x-%04x-%x-%x", binary.LittleEndian.Uint32(uuid), binary.LittleEndian.Uint16(uuid[4:]), binary.LittleEndian.Uint16(uuid[6:]), uuid[8:10], uuid[10:])
+			}
+			if m := qemuMachineRegex.FindStringSubmatch(version); m != nil {
+				if m[3] != "" {
+					warn("machine type %s reveals Proxmox VE running QEMU %s or later", m[0], m[2])
+				} else {
+					warn("machine type %s reveals QEMU %s or later on the host", m[0], m[2])
+				}
+			}
+			if isHardwareVendor(manufacturer) && !isSMBIOSPlaceholder(serial) {
+				warn("system serial %s of a %s %s, the host's identity is passed through", serial, manufacturer, product)
+			}
+
+		case smbiosBaseboard:
+			manufacturer, product, serial := s.str(4), s.str(5), s.str(7)
+			log.Printf("[VM][SMBIOS] Baseboard: %s %s, serial %s, asset tag %s", manufacturer, product, serial, s.str(8))
+			if isHardwareVendor(manufacturer) && !isSMBIOSPlaceholder(serial) {
+				warn("baseboard serial %s of a %s %s", serial, manufacturer, product)
+			}
+
+		case smbiosChassis:
+			chassisType := s.byteAt(5) & 0x7f
+			serial, asset := s.str(7), s.str(8)
+			log.Printf("[VM][SMBIOS] Chassis: %s type %#x, serial %s, asset tag %s", s.str(4), chassisType, serial, asset)
+			if name, ok := physicalChassisTypes[chassisType]; ok && (!isSMBIOSPlaceholder(serial) || !isSMBIOSPlaceholder(asset)) {
+				warn("physical chassis data (%s, serial %s, asset tag %s)", name, serial, asset)
+			}
+
+		case smbiosProcessor:
+			version := s.str(0x10)
+			log.Printf("[VM][SMBIOS] Processor %s: %s %s, max %d MHz, current %d MHz, %d cores", s.str(4), s.str(7), version, s.word(0x14), s.word(0x16), s.byteAt(0x23))
+			if !isSMBIOSPlaceholder(s.str(0x20)) && isHardwareVendor(s.str(7)) {
+				warn("processor serial %s", s.str(0x20))
+			}
+
+		case smbiosOEMStrings:
+			for i, value := range s.Strings {
+				if m := systemdCredentialRegex.FindStringSubmatch(value); m != nil {
+					warn("OEM string %d passes systemd credential %s to the guest: %s", i+1, m[2], redactSecret(m[3]))
+					continue
+				}
+				log.Printf("[VM][SMBIOS] OEM string %d: %s", i+1, redactOEMString(value))
+				if scanReaderForSecrets(fmt.Sprintf("smbios:oem-string-%d", i+1), strings.NewReader(value)) > 0 {
+					passed = false
+				}
+				// Only look at the value of key=value strings, keys like io.systemd.stub look like domain names
+				hostText := value
+				if _, v, ok := strings.Cut(value, "="); ok {
+					hostText = v
+				}
+				if name := hostnameRegex.FindString(hostText); name != "" {
+					warn("OEM string %d names a host: %s", i+1, name)
+				}
+			}
+
+		case smbiosMemoryDevice:
+			manufacturer, serial, part := s.str(0x17), s.str(0x18), s.str(0x1a)
+			if s.word(0x0c) == 0 {
+				continue
+			}
+			log.Printf("[VM][SMBIOS] Memory %s: %s, serial %s, part %s", s.str(0x10), manufacturer, serial, part)
+			if isHardwareVendor(manufacturer) || (!isSMBIOSPlaceholder(serial) && !isSMBIOSPlaceholder(part)) {
+				warn("memory device %s describes a physical DIMM (%s %s, serial %s)", s.str(0x10), manufacturer, part, serial)
+			}
+
+		case smbiosIPMIDevice:
+			var base uint64
+			if len(s.Data) >= 0x10 {
+				base = binary.LittleEndian.Uint64(s.Data[8:])
+			}
+			warn("IPMI device (interface type %d, base address %#x) described to the guest, check whether the host BMC is reachable", s.byteAt(4), base)
+
+		case smbiosOnboardDevice:
+			designation := s.str(4)
+			log.Printf("[VM][SMBIOS] Onboard device %s (type %#x, bus %02x, devfn %02x)", designation, s.byteAt(5)&0x7f, s.byteAt(9), s.byteAt(10))
+			if designation != "" {
+				warn("onboard device %s is the host's physical device list", designation)
+			}
+		}
+	}
+	return passed
+}
+
+// CheckSMBIOS parses the raw SMBIOS table, a superset of what /sys/class/dmi/id exposes
+func CheckSMBIOS() bool {
+	structures, err := readSMBIOS()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Println("[VM][SMBIOS] No SMBIOS table")
+		} else {
+			log.Printf("[VM][SMBIOS] Failed to parse the SMBIOS table: %v", err)
+		}
+		return true
+	}
+	log.Printf("[VM][SMBIOS] %d structures", len(structures))
+	return analyzeSMBIOS(structures)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// spoofCandidate is one source address to test. Replies to Routable addresses come back to us,
+// so a round trip shows whether the provider lets the source out; other classes can only be
+// observed from the far end.
+type spoofCandidate struct {
+	Class    string
+	IP       net.IP
+	Routable bool
+}
+
+const (
+	spoofAllowed    = "ALLOWED"
+	spoofBlocked    = "blocked"
+	spoofTranslated = "translated"
+	spoofUntestable = "untestable"
+	spoofError      = "error"
+)
+
+type spoofResult struct {
+	Interface string
+	Family    string
+	Class     string
+	IP        string
+	Status    string
+	Detail    string
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// randomInPrefix returns a random address inside prefix that isn't the network address
+func randomInPrefix(prefix netip.Prefix) net.IP {
+	base := prefix.Masked().Addr().AsSlice()
+	random := randomBytes(len(base))
+	bits := prefix.Bits()
+	for i := range base {
+		hostBits := 0
+		if bits < (i+1)*8 {
+			hostBits = (i+1)*8 - max(bits, i*8)
+		}
+		mask := byte(0xff >> (8 - hostBits))
+		base[i] |= random[i] & mask
+	}
+	if addr, ok := netip.AddrFromSlice(base); ok && addr == prefix.Masked().Addr() {
+		base[len(base)-1] |= 1
+	}
+	return net.IP(base)
+}
+
+// adjacentPrefix returns the prefix of the same size that directly follows prefix
+func adjacentPrefix(prefix netip.Prefix) netip.Prefix {
+	addr := prefix.Masked().Addr()
+	bits := prefix.Bits()
+	raw := addr.AsSlice()
+	// Add one at the last network bit
+	byteIndex, bitIndex := (bits-1)/8, 7-(bits-1)%8
+	for i := byteIndex; i >= 0; i-- {
+		carry := uint16(raw[i])
+		if i == byteIndex {
+			carry += 1 << bitIndex
+		} else {
+			carry++
+		}
+		raw[i] = byte(carry)
+		if carry <= 0xff {
+			break
+		}
+	}
+	next, _ := netip.AddrFromSlice(raw)
+	return netip.PrefixFrom(next, bits)
+}
+
+func isPublicAddress(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, bogon := range []string{"100.64.0.0/10", "192.0.2.0/24", "198.18.0.0/15", "198.51.100.0/24",
+		"203.0.113.0/24", "240.0.0.0/4", "2001:db8::/32", "64:ff9b::/96"} {
+		if netip.MustParsePrefix(bogon).Contains(addr.Unmap()) {
+			return false
+		}
+	}
+	return true
+}
+
+func isInARPOrNeighbourTable(ip net.IP) bool {
+	neighbors, err := netlink.NeighList(0, netlink.FAMILY_ALL)
+	if err != nil {
+		return false
+	}
+	for _, neigh := range neighbors {
+		if neigh.IP.Equal(ip) && len(neigh.HardwareAddr) > 0 && neigh.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// freeAddressInSubnet picks a random host address in subnet that isn't the network or broadcast
+// address, the gateway, our own address or in use by a neighbour
+func freeAddressInSubnet(subnet netip.Prefix, own net.IP, family int, iface string) net.IP {
+	if subnet.Bits() >= 31 {
+		return nil
+	}
+	gateway, _, _ := GetDefaultGateway(family)
+	broadcast := adjacentPrefix(subnet.Masked()).Addr().Prev()
+	for i := 0; i < 10; i++ {
+		ip := randomInPrefix(subnet)
+		if addr, _ := netip.AddrFromSlice(ip); addr == broadcast || ip.Equal(own) || ip.Equal(gateway) {
+			continue
+		}
+		if isInARPOrNeighbourTable(ip) || (!config.DryRun && PingIP(ip, time.Second, iface)) {
+			continue
+		}
+		return ip
+	}
+	return nil
+}
+
+// spoofCandidates builds one source address per class for the given interface address
+func spoofCandidates(addr *netlink.Addr, neighborIP net.IP, iface string) []spoofCandidate {
+	prefix, err := netip.ParsePrefix(addr.IPNet.String())
+	if err != nil {
+		return nil
+	}
+	isIPv6 := addr.IP.To4() == nil
+
+	var candidates []spoofCandidate
+	if isIPv6 {
+		// Providers commonly filter by /64 rather than by the exact address
+		own64 := netip.PrefixFrom(prefix.Addr(), 64)
+		candidates = append(candidates, spoofCandidate{"Other address in our /64", randomInPrefix(own64), true})
+		if neighborIP != nil {
+			candidates = append(candidates, spoofCandidate{"Live neighbour", neighborIP, false})
+		}
+		candidates = append(candidates,
+			spoofCandidate{"Adjacent /64", randomInPrefix(adjacentPrefix(own64)), false},
+		)
+	} else {
+		subnet := prefix
+		if subnet.Bits() > 24 {
+			subnet = netip.PrefixFrom(prefix.Addr(), 24)
+		}
+		if free := freeAddressInSubnet(subnet.Masked(), addr.IP, netlink.FAMILY_V4, iface); free != nil {
+			candidates = append(candidates, spoofCandidate{"Free address in our subnet", free, true})
+		}
+		if neighborIP != nil {
+			candidates = append(candidates, spoofCandidate{"Live neighbour", neighborIP, false})
+		}
+		candidates = append(candidates,
+			spoofCandidate{"Adjacent subnet", randomInPrefix(adjacentPrefix(subnet.Masked())), false},
+		)
+	}
+
+	var public net.IP
+	for public == nil || !isPublicAddress(public) || addr.IPNet.Contains(public) {
+		if isIPv6 {
+			public = randomInPrefix(netip.MustParsePrefix("2000::/3"))
+		} else {
+			public = randomInPrefix(netip.MustParsePrefix("0.0.0.0/0"))
+		}
+	}
+	candidates = append(candidates, spoofCandidate{"Random public address", public, false})
+
+	if isIPv6 {
+		candidates = append(candidates,
+			spoofCandidate{"Unique local (fc00::/7)", randomInPrefix(netip.MustParsePrefix("fd00::/8")), false},
+			spoofCandidate{"Bogon (2001:db8::/32)", randomInPrefix(netip.MustParsePrefix("2001:db8::/32")), false},
+		)
+	} else {
+		candidates = append(candidates,
+			spoofCandidate{"RFC1918 (10.0.0.0/8)", randomInPrefix(netip.MustParsePrefix("10.0.0.0/8")), false},
+			spoofCandidate{"Bogon (192.0.2.0/24)", randomInPrefix(netip.MustParsePrefix("192.0.2.0/24")), false},
+		)
+	}
+
+	return candidates
+}
+
+// GetExternalIPFromSource fetches our external IP with connections bound to the given source address
+func GetExternalIPFromSource(source net.IP, timeout time.Duration) (string, error) {
+	network := "tcp4"
+	if source.To4() == nil {
+		network = "tcp6"
+	}
+	dialer := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: source},
+		Timeout:   timeout,
+	}
+
+	httpClient := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	req, err := http.NewRequest("GET", "http://ip.me", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "curl/8.0.0")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	ipStr := strings.TrimSpace(string(body))
+	if net.ParseIP(ipStr) == nil {
+		return "", fmt.Errorf("invalid IP address received: %s", ipStr)
+	}
+	return ipStr, nil
+}
+
+// testSpoofCandidate temporarily adds the candidate as an extra host address next to our own and
+// sends traffic from it. With a reflector the far end reports the source it saw, so every class
+// can be tested. Without one only routable classes are, by whether a request to ip.me comes back.
+func testSpoofCandidate(iface string, source net.IP, candidate spoofCandidate) spoofResult {
+	result := spoofResult{Interface: iface, Class: candidate.Class, IP: candidate.IP.String()}
+
+	if config.Reflector == "" && !candidate.Routable {
+		result.Status = spoofUntestable
+		result.Detail = "replies are not routed back to us, use -reflector to observe egress"
+		return result
+	}
+
+	if err := AddHostIP(iface, candidate.IP); err != nil {
+		result.Status = spoofError
+		result.Detail = "failed to add address: " + err.Error()
+		return result
+	}
+	defer DeleteHostIP(iface, candidate.IP)
+	time.Sleep(500 * time.Millisecond)
+
+	var seen string
+	var err error
+	if config.Reflector != "" {
+		seen, err = watchSpoofedSource(config.Reflector, source, candidate.IP)
+		if err != nil {
+			result.Status = spoofError
+			result.Detail = err.Error()
+			return result
+		}
+	} else if seen, err = GetExternalIPFromSource(candidate.IP, 10*time.Second); err != nil {
+		seen = ""
+	}
+
+	switch {
+	case seen == "":
+		result.Status = spoofBlocked
+		result.Detail = "not seen by the far end"
+	case seen == candidate.IP.String():
+		result.Status = spoofAllowed
+		result.Detail = "seen as " + seen
+	default:
+		result.Status = spoofTranslated
+		result.Detail = "source rewritten to " + seen
+	}
+	return result
+}
+
+// RunSpoofMatrix tests several classes of source addresses and prints which ones the provider lets through
+func RunSpoofMatrix(iface string, family int, addr *netlink.Addr, neighborIP net.IP) []spoofResult {
+	familyName := "IPv4"
+	if family == netlink.FAMILY_V6 {
+		familyName = "IPv6"
+	}
+
+	log.Printf("Running %s spoofing matrix on %s (source %s)", familyName, iface, addr.IPNet)
+	var candidates []spoofCandidate
+	for _, candidate := range spoofCandidates(addr, neighborIP, iface) {
+		if inScope(candidate.IP.String()) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if config.DryRun {
+		for _, candidate := range candidates {
+			switch {
+			case config.Reflector != "":
+				AddHostIP(iface, candidate.IP)
+				planAction("packets", config.Reflector, fmt.Sprintf("UDP datagram with source %s (%s) observed by the reflector", candidate.IP, candidate.Class))
+				DeleteHostIP(iface, candidate.IP)
+			case candidate.Routable:
+				AddHostIP(iface, candidate.IP)
+				planAction("http", "http://ip.me", fmt.Sprintf("GET with source %s (%s)", candidate.IP, candidate.Class))
+				DeleteHostIP(iface, candidate.IP)
+			}
+		}
+		return nil
+	}
+
+	var results []spoofResult
+	for _, candidate := range candidates {
+		log.Printf("Testing %s: %s...", candidate.Class, candidate.IP)
+		result := testSpoofCandidate(iface, addr.IP, candidate)
+		result.Family = familyName
+		results = append(results, result)
+	}
+
+	log.Printf("[NETWORK][%s][%s] Spoofing matrix:", iface, familyName)
+	log.Printf("  %-28s %-40s %-10s %s", "Class", "Address", "Result", "Detail")
+	for _, r := range results {
+		log.Printf("  %-28s %-40s %-10s %s", r.Class, r.IP, r.Status, r.Detail)
+	}
+
+	for _, r := range results {
+		if r.Status == spoofAllowed {
+			log.Printf("[NETWORK][%s][%s] Spoofing allowed for class: %s (%s)", iface, familyName, r.Class, r.IP)
+		}
+	}
+	return results
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+type storageService struct {
+	Name      string
+	Ports     string
+	Platforms []string
+	// Confirm performs a protocol-level check and returns a description, or an error if the
+	// service on the port doesn't speak the expected protocol
+	Confirm func(host string, port int, timeout time.Duration) (string, error)
+}
+
+const (
+	rpcProgPortmap = 100000
+	rpcProgNFS     = 100003
+	rpcProgGluster = 14398633 // GlusterFS handshake program
+)
+
+// Services without a Confirm only have a well-known port, an open port is reported but not
+// counted as a finding
+var storageServices = []storageService{
+	{"rpcbind", "111", []string{"proxmox", "openstack", "solusvm", "xcp-ng"}, rpcConfirm(rpcProgPortmap, 2)},
+	{"NFS", "2049", []string{"proxmox", "openstack", "solusvm", "xcp-ng", "vmware"}, rpcConfirm(rpcProgNFS, 3)},
+	{"iSCSI", "3260", []string{"proxmox", "openstack", "solusvm", "xcp-ng", "vmware"}, iscsiConfirm},
+	{"Ceph monitor (msgr v1)", "6789", []string{"proxmox", "openstack"}, bannerConfirm("ceph v")},
+	{"Ceph monitor (msgr v2)", "3300", []string{"proxmox", "openstack"}, bannerConfirm("ceph v2")},
+	{"GlusterFS", "24007-24008", []string{"proxmox", "openstack"}, rpcConfirm(rpcProgGluster, 2)},
+	{"Xen relocation", "8002", []string{"xcp-ng", "xen"}, nil},
+	{"VMware vMotion", "8000", []string{"vmware"}, nil},
+}
+
+// Live migration listeners are only scanned on hosts that already expose other storage or
+// cluster services, the range is too large to sweep a whole subnet
+var migrationService = storageService{"QEMU live migration/GlusterFS brick", "49152-49215", []string{"proxmox", "openstack"}, migrationConfirm}
+
+// Corosync's knet transport silently drops unauthenticated packets, so these ports can only
+// be reported as not rejected, an open port can't be told apart from a filtered one
+var corosyncPorts = []int{5404, 5405}
+
+// rpcNullCall sends an ONC RPC NULL procedure call over TCP and reports whether a valid RPC
+// reply came back. PROG_UNAVAIL and PROG_MISMATCH replies still confirm an RPC service.
+func rpcNullCall(host string, port int, prog, vers uint32, timeout time.Duration) (uint32, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	xid := rand.Uint32()
+	call := make([]byte, 44)
+	binary.BigEndian.PutUint32(call[0:], 0x80000000|40) // Last fragment, 40 bytes
+	binary.BigEndian.PutUint32(call[4:], xid)
+	binary.BigEndian.PutUint32(call[8:], 0)  // CALL
+	binary.BigEndian.PutUint32(call[12:], 2) // RPC version
+	binary.BigEndian.PutUint32(call[16:], prog)
+	binary.BigEndian.PutUint32(call[20:], vers)
+	// Procedure 0 (NULL), AUTH_NONE credentials and verifier are all zeros
+	if _, err := conn.Write(call); err != nil {
+		return 0, err
+	}
+
+	reply := make([]byte, 28)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return 0, err
+	}
+	if binary.BigEndian.Uint32(reply[4:]) != xid || binary.BigEndian.Uint32(reply[8:]) != 1 {
+		return 0, errors.New("not an ONC RPC reply")
+	}
+	// reply_stat MSG_ACCEPTED is followed by the (empty) verifier and accept_stat
+	if binary.BigEndian.Uint32(reply[12:]) != 0 {
+		return 0, errors.New("RPC call denied")
+	}
+	return binary.BigEndian.Uint32(reply[24:]), nil
+}
+
+func rpcConfirm(prog, vers uint32) func(string, int, time.Duration) (string, error) {
+	return func(host string, port int, timeout time.Duration) (string, error) {
+		stat, err := rpcNullCall(host, port, prog, vers, timeout)
+		if err != nil {
+			return "", err
+		}
+		switch stat {
+		case 0:
+			return fmt.Sprintf("RPC program %d v%d answers NULL call", prog, vers), nil
+		case 1:
+			return "RPC service (program unavailable on this port)", nil
+		case 2:
+			return fmt.Sprintf("RPC program %d (version %d not supported)", prog, vers), nil
+		default:
+			return fmt.Sprintf("RPC service (accept status %d)", stat), nil
+		}
+	}
+}
+
+func bannerConfirm(prefix string) func(string, int, time.Duration) (string, error) {
+	return func(host string, port int, timeout time.Duration) (string, error) {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		banner := make([]byte, len(prefix)+8)
+		n, _ := io.ReadAtLeast(conn, banner, len(prefix))
+		if !bytes.HasPrefix(banner[:n], []byte(prefix)) {
+			return "", errors.New("unexpected banner")
+		}
+		return "banner " + strconv.Quote(strings.TrimSpace(string(banner[:n]))), nil
+	}
+}
+
+// migrationConfirm tells GlusterFS bricks (ONC RPC) apart from QEMU migration listeners, which
+// accept the connection and wait silently for the migration stream
+func migrationConfirm(host string, port int, timeout time.Duration) (string, error) {
+	if _, err := rpcNullCall(host, port, rpcProgGluster, 2, timeout); err == nil {
+		return "GlusterFS brick (ONC RPC)", nil
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	if n, err := conn.Read(make([]byte, 64)); n == 0 && isTimeout(err) {
+		return "silent listener, consistent with a QEMU incoming migration", nil
+	}
+	return "", errors.New("listener sent unexpected data")
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// iscsiReadPDU reads one iSCSI PDU and returns its basic header segment and data segment
+func iscsiReadPDU(conn net.Conn) ([]byte, []byte, error) {
+	bhs := make([]byte, 48)
+	if _, err := io.ReadFull(conn, bhs); err != nil {
+		return nil, nil, err
+	}
+	ahsLen := int(bhs[4]) * 4
+	dataLen := int(bhs[5])<<16 | int(bhs[6])<<8 | int(bhs[7])
+	padded := (dataLen + 3) &^ 3
+	rest := make([]byte, ahsLen+padded)
+	if _, err := io.ReadFull(conn, rest); err != nil {
+		return nil, nil, err
+	}
+	return bhs, rest[ahsLen : ahsLen+dataLen], nil
+}
+
+func iscsiPDU(bhs []byte, data string) []byte {
+	dataLen := len(data)
+	bhs[5], bhs[6], bhs[7] = byte(dataLen>>16), byte(dataLen>>8), byte(dataLen)
+	pdu := append(bhs, data...)
+	for len(pdu)%4 != 0 {
+		pdu = append(pdu, 0)
+	}
+	return pdu
+}
+
+// iscsiConfirm logs in to a discovery session and, if no authentication is required, asks
+// for SendTargets=All
+func iscsiConfirm(host string, port int, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * timeout))
+
+	login := make([]byte, 48)
+	login[0] = 0x43                                               // Immediate, Login Request
+	login[1] = 0x87                                               // Transit, CSG operational, NSG full feature
+	copy(login[8:14], []byte{0x40, 0x00, 0x01, 0x37, 0x00, 0x00}) // ISID, random qualifier format
+	binary.BigEndian.PutUint32(login[16:], 1)                     // Initiator task tag
+	binary.BigEndian.PutUint32(login[24:], 1)                     // CmdSN
+	keys := "InitiatorName=iqn.2024-01.wiki.hostile:probe\x00SessionType=Discovery\x00" +
+		"HeaderDigest=None\x00DataDigest=None\x00"
+	if _, err := conn.Write(iscsiPDU(login, keys)); err != nil {
+		return "", err
+	}
+
+	resp, _, err := iscsiReadPDU(conn)
+	if err != nil {
+		return "", err
+	}
+	if resp[0]&0x3f != 0x23 {
+		return "", errors.New("not an iSCSI login response")
+	}
+	if resp[36] != 0 {
+		return fmt.Sprintf("iSCSI target (discovery login rejected, status %d/%d)", resp[36], resp[37]), nil
+	}
+
+	text := make([]byte, 48)
+	text[0] = 0x04                                    // Text Request
+	text[1] = 0x80                                    // Final
+	binary.BigEndian.PutUint32(text[16:], 2)          // Initiator task tag
+	binary.BigEndian.PutUint32(text[20:], 0xffffffff) // Target transfer tag
+	binary.BigEndian.PutUint32(text[24:], 1)          // CmdSN
+	binary.BigEndian.PutUint32(text[28:], binary.BigEndian.Uint32(resp[24:])+1)
+	if _, err := conn.Write(iscsiPDU(text, "SendTargets=All\x00")); err != nil {
+		return "iSCSI target (discovery login allowed without authentication)", nil
+	}
+
+	var targets []string
+	if textResp, data, err := iscsiReadPDU(conn); err == nil && textResp[0]&0x3f == 0x24 {
+		for _, kv := range strings.Split(string(data), "\x00") {
+			if name, ok := strings.CutPrefix(kv, "TargetName="); ok {
+				targets = append(targets, name)
+			}
+		}
+	}
+	return fmt.Sprintf("iSCSI target (discovery login allowed without authentication, %d targets: %s)",
+		len(targets), strings.Join(targets, ", ")), nil
+}
+
+// udpPortRejected sends a datagram and reports true only when the host answers with ICMP
+// port unreachable
+func udpPortRejected(host string, port int, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.Write([]byte{0})
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err = conn.Read(make([]byte, 512))
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// storageTargets returns the hosts of the gateway's subnet and samples of RFC1918 ranges
+// that have explicit routes through a gateway
+func storageTargets() []string {
+	var targets []string
+	seen := make(map[string]bool)
+	add := func(ip net.IP) {
+		if !seen[ip.String()] && inScope(ip.String()) {
+			seen[ip.String()] = true
+			targets = append(targets, ip.String())
+		}
+	}
+
+	addNetwork := func(network *net.IPNet, max int) {
+		ip := network.IP.Mask(network.Mask).To4()
+		if ip == nil {
+			return
+		}
+		start := binary.BigEndian.Uint32(ip)
+		ones, bits := network.Mask.Size()
+		size := uint32(1) << uint(bits-ones)
+		for i := uint32(1); i < size-1 && i <= uint32(max); i++ {
+			host := make(net.IP, 4)
+			binary.BigEndian.PutUint32(host, start+i)
+			add(host)
+		}
+	}
+
+	gateway, iface, err := GetDefaultGateway(netlink.FAMILY_V4)
+	if err != nil {
+		log.Printf("Failed to detect the default gateway: %s", err.Error())
+		return nil
+	}
+	add(gateway)
+
+	if addr, err := GetInterfaceAddr(iface, netlink.FAMILY_V4); err == nil {
+		if addr.IPNet.Contains(gateway) {
+			addNetwork(addr.IPNet, 1024)
+		}
+	}
+	// Fall back to the gateway's /24 when our own prefix is a /32 or doesn't contain it
+	if len(targets) < 2 {
+		addNetwork(&net.IPNet{IP: gateway, Mask: net.CIDRMask(24, 32)}, 254)
+	}
+
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return targets
+	}
+	for _, route := range routes {
+		if route.Dst == nil || route.Gw == nil || !isRFC1918(route.Dst.IP) {
+			continue
+		}
+		if ones, _ := route.Dst.Mask.Size(); ones == 0 {
+			continue
+		}
+		add(route.Gw)
+		addNetwork(route.Dst, 16)
+	}
+
+	return targets
+}
+
+func isRFC1918(ip net.IP) bool {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func platformNote(platforms []string, detected string) string {
+	for _, p := range platforms {
+		if p == detected {
+			return "matches detected platform " + detected
+		}
+	}
+	return "typical for " + strings.Join(platforms, ", ")
+}
+
+// CheckStorageExposure looks for the provider's storage, cluster and migration networks
+func CheckStorageExposure(platform string) {
+	timeout := 1 * time.Second
+	targets := storageTargets()
+	if len(targets) == 0 {
+		return
+	}
+
+	portService := make(map[int]storageService)
+	var ports []int
+	for _, svc := range storageServices {
+		svcPorts, _ := parsePorts(svc.Ports)
+		for _, port := range svcPorts {
+			portService[port] = svc
+			ports = append(ports, port)
+		}
+	}
+
+	log.Printf("\nScanning %d hosts for storage and cluster services", len(targets))
+	if config.DryRun {
+		planAction("packets", strings.Join(targets, ","), fmt.Sprintf("TCP connect to ports %v, protocol confirmation (RPC NULL, iSCSI discovery login, Ceph banner) on open ports", ports))
+		planAction("packets", "hosts with open storage ports", "TCP connect to "+migrationService.Ports)
+		planAction("packets", "hosts with open storage ports", fmt.Sprintf("UDP datagram to Corosync ports %v", corosyncPorts))
+		return
+	}
+	open := scanHostsTCP(targets, ports, timeout)
+
+	if len(open) > 0 {
+		hosts := make([]string, 0, len(open))
+		for host := range open {
+			hosts = append(hosts, host)
+		}
+		migrationPorts, _ := parsePorts(migrationService.Ports)
+		for host, hostPorts := range scanHostsTCP(hosts, migrationPorts, timeout) {
+			for _, port := range hostPorts {
+				portService[port] = migrationService
+			}
+			open[host] = append(open[host], hostPorts...)
+		}
+	}
+
+	hosts := make([]string, 0, len(open))
+	for host := range open {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	confirmed := 0
+	for _, host := range hosts {
+		for _, port := range open[host] {
+			svc := portService[port]
+			if svc.Confirm == nil {
+				log.Printf("%s:%d/tcp is open, the usual port for %s but not confirmed (%s)",
+					host, port, svc.Name, platformNote(svc.Platforms, platform))
+				continue
+			}
+			detail, err := svc.Confirm(host, port, 3*time.Second)
+			if err != nil {
+				log.Printf("%s:%d/tcp is open but isn't %s: %s", host, port, svc.Name, err.Error())
+				continue
+			}
+			confirmed++
+			log.Printf("[NETWORK][Storage] %s reachable at %s:%d/tcp: %s (%s)",
+				svc.Name, host, port, detail, platformNote(svc.Platforms, platform))
+		}
+
+		// Corosync only runs on cluster nodes, so UDP is only worth checking on hosts that
+		// already look like part of the storage/cluster fabric
+		for _, port := range corosyncPorts {
+			if !udpPortRejected(host, port, timeout) {
+				log.Printf("%s:%d/udp (Corosync) not rejected, open or filtered (%s)",
+					host, port, platformNote([]string{"proxmox"}, platform))
+			}
+		}
+	}
+
+	if confirmed == 0 {
+		log.Println("No storage or cluster services reachable from this host")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Well-known virtio-serial channel names and the software on the other side
+var virtioChannels = map[string]string{
+	"org.qemu.guest_agent.0":   "QEMU guest agent",
+	"com.redhat.spice.0":       "SPICE vdagent (clipboard, display)",
+	"org.libguestfs.channel.0": "libguestfs",
+	"com.redhat.rhevm.vdsm":    "oVirt/RHV guest agent",
+	"ovirt-guest-agent.0":      "oVirt guest agent",
+	"org.linux-kvm.port.0":     "generic KVM port",
+	"org.qemu.vdagent.0":       "QEMU vdagent",
+}
+
+// guest agent RPCs that give the host operator control over the guest
+var dangerousGuestAgentRPCs = map[string]string{
+	"guest-exec":                    "execute commands",
+	"guest-file-open":               "read and write files",
+	"guest-file-write":              "write files",
+	"guest-set-user-password":       "change user passwords",
+	"guest-ssh-add-authorized-keys": "add SSH keys",
+	"guest-ssh-get-authorized-keys": "read SSH keys",
+	"guest-set-memory-blocks":       "offline memory",
+	"guest-fsfreeze-freeze":         "freeze filesystems",
+	"guest-network-get-interfaces":  "read network configuration",
+}
+
+var qemuGAConfigFiles = []string{"/etc/qemu/qemu-ga.conf", "/etc/qemu-ga.conf"}
+
+// virtioPorts returns the virtio-serial channel names exposed to the guest
+func virtioPorts() []string {
+	seen := make(map[string]bool)
+	ports, _ := filepath.Glob("/sys/class/virtio-ports/vport*")
+	for _, port := range ports {
+		if data, err := os.ReadFile(port + "/name"); err == nil {
+			if name := strings.TrimSpace(string(data)); name != "" {
+				seen[name] = true
+			}
+		}
+	}
+	if links, err := os.ReadDir("/dev/virtio-ports"); err == nil {
+		for _, link := range links {
+			seen[link.Name()] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findProcess returns the command line of the first process whose name matches one of names
+func findProcess(names ...string) ([]string, bool) {
+	procs, _ := filepath.Glob("/proc/[0-9]*/comm")
+	for _, comm := range procs {
+		data, err := os.ReadFile(comm)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(data))
+		for _, want := range names {
+			if name == want {
+				cmdline, _ := os.ReadFile(filepath.Join(filepath.Dir(comm), "cmdline"))
+				var args []string
+				for _, arg := range bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0}) {
+					args = append(args, string(arg))
+				}
+				return args, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// guestAgentRPCFilter returns the allow and block lists qemu-ga runs with, from its command line
+// and configuration file. Command line options take precedence.
+func guestAgentRPCFilter(args []string) (allow, block []string) {
+	configFiles := qemuGAConfigFiles
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+		}
+		switch name {
+		case "-c", "--config":
+			configFiles = []string{value}
+		}
+	}
+
+	for _, file := range configFiles {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), "=")
+			if !ok {
+				continue
+			}
+			switch strings.TrimSpace(key) {
+			case "allow-rpcs":
+				allow = splitRPCs(value)
+			case "block-rpcs", "blacklist":
+				block = splitRPCs(value)
+			}
+		}
+		f.Close()
+		break
+	}
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if !hasValue {
+			if i+1 >= len(args) {
+				continue
+			}
+			value = args[i+1]
+		}
+		switch name {
+		case "-a", "--allow-rpcs":
+			allow = splitRPCs(value)
+		case "-b", "--block-rpcs", "--blacklist":
+			block = splitRPCs(value)
+		}
+	}
+	return allow, block
+}
+
+func splitRPCs(value string) []string {
+	var rpcs []string
+	for _, rpc := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '"' || r == '\'' }) {
+		rpcs = append(rpcs, strings.TrimSpace(rpc))
+	}
+	return rpcs
+}
+
+// rpcEnabled applies qemu-ga's filtering: with an allow list only listed RPCs are enabled,
+// otherwise everything not blocked is
+func rpcEnabled(rpc string, allow, block []string) bool {
+	contains := func(list []string) bool {
+		for _, item := range list {
+			if item == rpc {
+				return true
+			}
+		}
+		return false
+	}
+	if len(allow) > 0 {
+		return contains(allow) && !contains(block)
+	}
+	return !contains(block)
+}
+
+// CheckGuestAgent enumerates virtio-serial channels and reports what a running QEMU guest agent
+// lets the host operator do inside this VM
+func CheckGuestAgent() bool {
+	ports := virtioPorts()
+	if len(ports) == 0 {
+		log.Println("[KVM][VirtioPorts] No virtio-serial channels")
+	}
+	hasAgentChannel := false
+	for _, port := range ports {
+		purpose, ok := virtioChannels[port]
+		if !ok {
+			purpose = "unknown, possibly a provider agent"
+		}
+		if strings.HasPrefix(port, "org.qemu.guest_agent.") {
+			hasAgentChannel = true
+		}
+		log.Printf("[KVM][VirtioPorts] Channel %s: %s", port, purpose)
+	}
+
+	args, running := findProcess("qemu-ga", "qemu-guest-agen")
+	if !running {
+		if hasAgentChannel {
+			log.Println("[KVM][GuestAgent] Guest agent channel present but qemu-ga is not running")
+		}
+		return true
+	}
+	log.Printf("[KVM][GuestAgent] qemu-ga is running: %s", strings.Join(args, " "))
+
+	allow, block := guestAgentRPCFilter(args)
+	if len(allow) > 0 {
+		log.Printf("[KVM][GuestAgent] Allowed RPCs: %s", strings.Join(allow, ","))
+	}
+	if len(block) > 0 {
+		log.Printf("[KVM][GuestAgent] Blocked RPCs: %s", strings.Join(block, ","))
+	}
+
+	rpcs := make([]string, 0, len(dangerousGuestAgentRPCs))
+	for rpc := range dangerousGuestAgentRPCs {
+		rpcs = append(rpcs, rpc)
+	}
+	sort.Strings(rpcs)
+
+	passed := true
+	for _, rpc := range rpcs {
+		if rpcEnabled(rpc, allow, block) {
+			log.Printf("[KVM][GuestAgent] WARNING: %s is enabled, the host operator can %s in this VM", rpc, dangerousGuestAgentRPCs[rpc])
+			passed = false
+		}
+	}
+	if passed {
+		log.Println("[KVM][GuestAgent] Dangerous guest agent RPCs are blocked")
+	}
+	return passed
+}
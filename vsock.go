@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	vsockCIDHypervisor = 0
+	vsockCIDLocal      = 1
+	vsockCIDHost       = 2
+)
+
+const defaultVsockPorts = "1-1026,1234,2222,5000-5005,8000,8080,9000,9999,10000,10250"
+
+// Kernel modules implementing AF_VSOCK transports
+var vsockTransports = map[string]string{
+	"vmw_vsock_virtio_transport": "virtio-vsock (KVM/QEMU, Firecracker)",
+	"vmw_vsock_vmci_transport":   "VMCI (VMware)",
+	"hv_sock":                    "hv_sock (Hyper-V)",
+	"vhost_vsock":                "vhost-vsock (this host runs guests)",
+	"vsock_loopback":             "loopback",
+}
+
+// Services known to listen on vsock ports
+var vsockServices = map[int]string{
+	22:   "SSH (systemd-ssh-generator)",
+	1024: "Kata Containers agent",
+	1026: "Kata Containers debug console",
+	8000: "Nitro Enclaves vsock-proxy",
+}
+
+// vsockLocalCID asks the kernel for our context ID
+func vsockLocalCID() (uint32, error) {
+	fd, err := unix.Open("/dev/vsock", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+	return unix.IoctlGetUint32(fd, unix.IOCTL_VM_SOCKETS_GET_LOCAL_CID)
+}
+
+// vsockDial connects to cid:port with a timeout and returns the connection as a pollable file
+func vsockDial(cid, port uint32, timeout time.Duration) (*os.File, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.Connect(fd, &unix.SockaddrVM{CID: cid, Port: port})
+	if err == unix.EINPROGRESS {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+		n, perr := unix.Poll(fds, int(timeout.Milliseconds()))
+		switch {
+		case perr != nil:
+			err = perr
+		case n == 0:
+			err = unix.ETIMEDOUT
+		default:
+			var soErr int
+			soErr, err = unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+			if err == nil && soErr != 0 {
+				err = unix.Errno(soErr)
+			}
+		}
+	}
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), fmt.Sprintf("vsock:%d:%d", cid, port)), nil
+}
+
+// vsockBanner reads what a service sends first, or its answer to an HTTP request
+func vsockBanner(conn *os.File, timeout time.Duration) string {
+	buf := make([]byte, 512)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, _ := conn.Read(buf)
+	if n == 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		n, _ = conn.Read(buf)
+	}
+	banner := truncate(strings.TrimSpace(strings.Join(printableStrings(buf[:n], 1), " ")), 100)
+
+	lower := strings.ToLower(banner)
+	for _, fp := range managementFingerprints {
+		if strings.Contains(lower, fp.Needle) {
+			return fp.Product + " [" + banner + "]"
+		}
+	}
+	return banner
+}
+
+// scanVsockPorts returns the open ports on a CID and their banners
+func scanVsockPorts(cid uint32, ports []int, timeout time.Duration) map[int]string {
+	open := make(map[int]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 64)
+
+	for _, port := range ports {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(port int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			conn, err := vsockDial(cid, uint32(port), timeout)
+			if err != nil {
+				return
+			}
+			banner := vsockBanner(conn, timeout)
+			conn.Close()
+			mu.Lock()
+			open[port] = banner
+			mu.Unlock()
+		}(port)
+	}
+	wg.Wait()
+	return open
+}
+
+// loadedVsockTransports lists the AF_VSOCK transport modules in /proc/modules
+func loadedVsockTransports() []string {
+	data, err := os.ReadFile("/proc/modules")
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			if name, ok := vsockTransports[fields[0]]; ok {
+				found = append(found, name)
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// ScanVsock probes the host (CID 2), the hypervisor and neighbouring guest CIDs for services
+// listening on AF_VSOCK. vsock traffic never touches the network, so no firewall applies to it.
+func ScanVsock() {
+	transports := loadedVsockTransports()
+	for _, transport := range transports {
+		log.Printf("[VSOCK] Transport loaded: %s", transport)
+	}
+	if _, err := os.Stat("/dev/vsock"); err != nil && len(transports) == 0 {
+		log.Println("[VSOCK] No vsock device or transport, skipping")
+		return
+	}
+
+	cids := []uint32{vsockCIDHost, vsockCIDLocal, vsockCIDHypervisor}
+	localCID, err := vsockLocalCID()
+	if err != nil {
+		log.Printf("[VSOCK] Failed to read local CID: %v", err)
+	} else {
+		log.Printf("[VSOCK] Local CID: %d", localCID)
+		// Guests are usually numbered sequentially, other guests must never be reachable
+		for _, offset := range []int{-2, -1, 1, 2} {
+			if cid := int(localCID) + offset; cid > vsockCIDHost {
+				cids = append(cids, uint32(cid))
+			}
+		}
+	}
+
+	ports := config.VsockPorts
+	if config.DryRun {
+		for _, cid := range cids {
+			planAction("packets", fmt.Sprintf("vsock CID %d", cid), fmt.Sprintf("connect to %d vsock ports and read banners", len(ports)))
+		}
+		return
+	}
+
+	found := 0
+	for _, cid := range cids {
+		open := scanVsockPorts(cid, ports, time.Second)
+		openPorts := make([]int, 0, len(open))
+		for port := range open {
+			openPorts = append(openPorts, port)
+		}
+		sort.Ints(openPorts)
+
+		role := "host"
+		switch {
+		case cid == vsockCIDLocal:
+			role = "local"
+		case cid == vsockCIDHypervisor:
+			role = "hypervisor"
+		case cid > vsockCIDHost:
+			role = "other guest"
+		}
+		for _, port := range openPorts {
+			service := vsockServices[port]
+			if service == "" {
+				service = "unknown service"
+			}
+			// Local listeners are only reachable from inside this VM
+			if cid == vsockCIDLocal {
+				log.Printf("[VSOCK] CID %d (%s) port %d open (%s), banner: %s", cid, role, port, service, open[port])
+				continue
+			}
+			log.Printf("[VSOCK] WARNING: CID %d (%s) port %d open (%s), banner: %s", cid, role, port, service, open[port])
+			found++
+		}
+	}
+
+	if found == 0 {
+		log.Printf("[VSOCK] No vsock services found on the host, hypervisor or other guests (CIDs %v, %d ports)", cids, len(ports))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Guest-writable xenstore subtrees that XAPI reads into the VM's guest metrics and feature flags
+var xapiConsumedPaths = []string{"data", "attr", "control", "drivers", "feature", "memory"}
+
+var xcpngGuestAgents = []string{
+	"/usr/sbin/xe-daemon",
+	"/usr/sbin/xen-guest-agent",
+	"/usr/bin/xen-guest-agent",
+}
+
+// CheckXCPNGGuestTools reports which guest agent is installed and the tools version it advertises to XAPI
+func CheckXCPNGGuestTools() bool {
+	agent := ""
+	for _, path := range xcpngGuestAgents {
+		if _, err := os.Stat(path); err == nil {
+			agent = path
+			log.Printf("[XCP-ng][GuestTools] Guest agent found: %s", path)
+		}
+	}
+
+	xs, err := openXenstore()
+	if err != nil {
+		log.Printf("[XCP-ng][GuestTools] %v", err)
+		return true
+	}
+	defer xs.Close()
+
+	var version []string
+	for _, key := range []string{"MajorVersion", "MinorVersion", "MicroVersion", "BuildVersion"} {
+		if value, err := xs.Read("attr/PVAddons/" + key); err == nil {
+			version = append(version, strings.TrimSpace(value))
+		}
+	}
+	if len(version) == 0 {
+		if agent != "" {
+			log.Println("[XCP-ng][GuestTools] WARNING: guest agent installed but no tools version in xenstore (agent not running?)")
+			return false
+		}
+		log.Println("[XCP-ng][GuestTools] No guest tools reported in xenstore")
+		return true
+	}
+
+	log.Printf("[XCP-ng][GuestTools] Guest tools version %s advertised in attr/PVAddons", strings.Join(version, "."))
+	if distro, err := xs.Read("data/os_distro"); err == nil {
+		log.Printf("[XCP-ng][GuestTools] Reported OS: %s", distro)
+	}
+	return true
+}
+
+// CheckXCPNGXenstorePermissions lists the nodes XAPI consumes that this guest can write. Whatever
+// the guest writes there (IP addresses, OS version, PV driver and feature flags) ends up in XAPI
+// and Xen Orchestra as if the hypervisor had observed it.
+func CheckXCPNGXenstorePermissions() bool {
+	xs, err := openXenstore()
+	if err != nil {
+		log.Printf("[XCP-ng][Xenstore] %v", err)
+		return true
+	}
+	defer xs.Close()
+
+	domid, err := xs.DomID()
+	if err != nil {
+		log.Printf("[XCP-ng][Xenstore] Failed to read our domain ID: %v", err)
+		return true
+	}
+	home := fmt.Sprintf("/local/domain/%d", domid)
+
+	writable := 0
+	for _, path := range xapiConsumedPaths {
+		nodes := []string{path}
+		if children, err := xs.Directory(path); err == nil {
+			for _, child := range children {
+				nodes = append(nodes, path+"/"+child)
+			}
+		}
+		for _, node := range nodes {
+			perms, err := xs.GetPerms(node)
+			if err != nil || !xenstoreWritable(perms, domid) {
+				continue
+			}
+			log.Printf("[XCP-ng][Xenstore] WARNING: %s/%s is writable by the guest (perms %s) and consumed by XAPI",
+				home, node, formatXenstorePerms(perms))
+			writable++
+		}
+	}
+
+	if writable > 0 {
+		log.Printf("[XCP-ng][Xenstore] %d guest-writable nodes are trusted by XAPI, values reported by the guest must not be relied on", writable)
+		return false
+	}
+	log.Println("[XCP-ng][Xenstore] No guest-writable nodes consumed by XAPI found")
+	return true
+}
+
+// ReportXCPNGVIFLocking infers the VIF locking mode from the spoofing results. With locking-mode
+// "locked" (or a default of locked on the network) XCP-ng drops frames from addresses not assigned
+// to the VIF, so any spoofed address getting through means the VIF is unlocked or disabled.
+func ReportXCPNGVIFLocking(results []networkResult) {
+	if len(results) == 0 {
+		log.Println("[XCP-ng][VIFLocking] No spoofing results, run with -allow-intrusive disruptive to test VIF locking")
+		return
+	}
+	for _, r := range results {
+		switch {
+		case r.Spoofing == "" || r.Spoofing == "planned" || r.Spoofing == "failed" || r.Spoofing == "translated" ||
+			r.Spoofing == "no target" || r.Spoofing == "skipped":
+			log.Printf("[XCP-ng][VIFLocking] %s (%s) inconclusive: %s", r.Interface, r.Family, r.Spoofing)
+		case r.Spoofing == "blocked" || strings.HasPrefix(r.Spoofing, "0 classes allowed"):
+			log.Printf("[XCP-ng][VIFLocking] %s (%s) dropped spoofed traffic, consistent with locking-mode locked", r.Interface, r.Family)
+		default:
+			log.Printf("[XCP-ng][VIFLocking] WARNING: %s (%s) accepted spoofed traffic (%s), VIF locking-mode is unlocked or disabled",
+				r.Interface, r.Family, r.Spoofing)
+		}
+	}
+}
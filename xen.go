@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	xenstoreWalkLimit = 5000
+	xenstoreProbeKey  = "hostile-probe"
+)
+
+// walkXenstore visits every node below root we are allowed to list, depth first, and returns
+// how many nodes were visited. The walk stops after xenstoreWalkLimit nodes.
+func walkXenstore(xs *xenstoreClient, root string, maxDepth int, visit func(node string)) int {
+	visited := 0
+	var walk func(node string, depth int)
+	walk = func(node string, depth int) {
+		if visited >= xenstoreWalkLimit {
+			return
+		}
+		visited++
+		if visit != nil {
+			visit(node)
+		}
+		if depth >= maxDepth {
+			return
+		}
+		children, err := xs.Directory(node)
+		if err != nil {
+			return
+		}
+		for _, child := range children {
+			walk(path.Join(node, child), depth+1)
+		}
+	}
+	walk(root, 0)
+	return visited
+}
+
+// otherDomains lists the domain IDs under /local/domain except our own
+func otherDomains(xs *xenstoreClient, domid int) ([]int, error) {
+	entries, err := xs.Directory("/local/domain")
+	if err != nil {
+		return nil, err
+	}
+	var domains []int
+	for _, entry := range entries {
+		if id, err := strconv.Atoi(entry); err == nil && id != domid {
+			domains = append(domains, id)
+		}
+	}
+	return domains, nil
+}
+
+// reportXenBackends names the domains serving our devices. Backends in driver domains other than
+// dom0 reveal how the host is built.
+func reportXenBackends(xs *xenstoreClient) {
+	types, err := xs.Directory("device")
+	if err != nil {
+		return
+	}
+	for _, devType := range types {
+		devices, err := xs.Directory("device/" + devType)
+		if err != nil {
+			continue
+		}
+		for _, dev := range devices {
+			node := "device/" + devType + "/" + dev
+			backendID, err := xs.Read(node + "/backend-id")
+			if err != nil {
+				continue
+			}
+			backend, _ := xs.Read(node + "/backend")
+			name, err := xs.Read("/local/domain/" + backendID + "/name")
+			if err != nil {
+				name = "name not readable"
+			}
+			log.Printf("[Xen][Backends] %s/%s served by domain %s (%s) at %s", devType, dev, backendID, name, backend)
+		}
+	}
+}
+
+// CheckXenstoreAccess enumerates what this guest can read in xenstore: other domains, backends,
+// the VM and toolstack trees, and nodes our domain may write according to their permissions
+func CheckXenstoreAccess() bool {
+	xs, err := openXenstore()
+	if err != nil {
+		log.Printf("[Xen][Xenstore] %v", err)
+		return true
+	}
+	defer xs.Close()
+
+	passed := true
+	domid, err := xs.DomID()
+	if err != nil {
+		log.Printf("[Xen][Xenstore] Failed to read our domain ID: %v", err)
+		return true
+	}
+	home := fmt.Sprintf("/local/domain/%d", domid)
+	name, _ := xs.Read("name")
+	log.Printf("[Xen][Xenstore] Domain ID %d (%s), home %s", domid, name, home)
+
+	if top, err := xs.Directory("/"); err == nil {
+		for _, entry := range top {
+			count := walkXenstore(xs, "/"+entry, 8, nil)
+			log.Printf("[Xen][Xenstore] /%s readable: %d nodes", entry, count)
+		}
+	}
+
+	domains, err := otherDomains(xs, domid)
+	if err != nil {
+		log.Println("[Xen][Xenstore] /local/domain is not listable")
+	}
+	for _, id := range domains {
+		node := fmt.Sprintf("/local/domain/%d", id)
+		name, err := xs.Read(node + "/name")
+		if err != nil {
+			if _, err := xs.Directory(node); err != nil {
+				continue
+			}
+			name = "name not readable"
+		}
+		count := walkXenstore(xs, node, 6, nil)
+		if id == 0 && count <= 1 {
+			log.Printf("[Xen][Xenstore] dom0 listed as %s", name)
+			continue
+		}
+		log.Printf("[Xen][Xenstore] WARNING: can read domain %d (%s): %d nodes under %s", id, name, count, node)
+		passed = false
+	}
+
+	if vmPath, err := xs.Read("vm"); err == nil {
+		if vmName, err := xs.Read(vmPath + "/name"); err == nil {
+			log.Printf("[Xen][Xenstore] VM path %s readable, name %s", vmPath, vmName)
+		}
+	}
+
+	walkXenstore(xs, "/tool", 4, func(node string) {
+		if value, err := xs.Read(node); err == nil && value != "" {
+			log.Printf("[Xen][Tools] %s = %s", node, truncate(value, 80))
+		}
+	})
+
+	reportXenBackends(xs)
+
+	writable := 0
+	walkXenstore(xs, home, 6, func(node string) {
+		if perms, err := xs.GetPerms(node); err == nil && xenstoreWritable(perms, domid) {
+			log.Printf("[Xen][Xenstore] %s writable by the guest (perms %s)", node, formatXenstorePerms(perms))
+			writable++
+		}
+	})
+	for _, id := range domains {
+		walkXenstore(xs, fmt.Sprintf("/local/domain/%d", id), 6, func(node string) {
+			if perms, err := xs.GetPerms(node); err == nil && xenstoreWritable(perms, domid) {
+				log.Printf("[Xen][Xenstore] WARNING: %s in another domain is writable by us (perms %s)", node, formatXenstorePerms(perms))
+				passed = false
+			}
+		})
+	}
+	log.Printf("[Xen][Xenstore] %d nodes in our home are guest-writable", writable)
+
+	return passed
+}
+
+// probeXenstoreWrite creates and removes a probe key below target. xenstore creates missing
+// parents on write but only removes the leaf, so targets that don't exist are left alone.
+func probeXenstoreWrite(xs *xenstoreClient, target string) bool {
+	key := target + "/" + xenstoreProbeKey
+	if _, err := xs.Directory(target); err != nil {
+		log.Printf("[Xen][XenstoreWrite] %s does not exist, not probing it", target)
+		return false
+	}
+	if _, err := xs.Read(key); err == nil {
+		log.Printf("[Xen][XenstoreWrite] %s already exists, not probing it", key)
+		return false
+	}
+	if dryRunSkip("xenstore", key, "write and remove a probe key") {
+		return false
+	}
+	if err := xs.Write(key, "1"); err != nil {
+		log.Printf("[Xen][XenstoreWrite] %s not writable (%s)", target, strings.TrimPrefix(err.Error(), "xenstore: "))
+		return false
+	}
+	if err := xs.Rm(key); err != nil {
+		log.Printf("[VM][Xen] WARNING: failed to remove the probe key, %s is left behind (%s)",
+			key, strings.TrimPrefix(err.Error(), "xenstore: "))
+	}
+	return true
+}
+
+// openXenstoreHome opens xenstore and returns our domain ID
+func openXenstoreHome() (*xenstoreClient, int, error) {
+	xs, err := openXenstore()
+	if err != nil {
+		return nil, 0, err
+	}
+	domid, err := xs.DomID()
+	if err != nil {
+		xs.Close()
+		return nil, 0, fmt.Errorf("failed to read our domain ID: %w", err)
+	}
+	return xs, domid, nil
+}
+
+// CheckXenstoreWriteHome confirms we can write below our own home, which the guest agents rely on
+func CheckXenstoreWriteHome() bool {
+	xs, domid, err := openXenstoreHome()
+	if err != nil {
+		log.Printf("[Xen][XenstoreWrite] %v", err)
+		return true
+	}
+	defer xs.Close()
+
+	target := fmt.Sprintf("/local/domain/%d/data", domid)
+	if probeXenstoreWrite(xs, target) {
+		log.Printf("[Xen][XenstoreWrite] %s is writable (own domain)", target)
+	}
+	return true
+}
+
+// CheckXenstoreWriteForeign tries to create and remove a probe key in other domains and in the
+// toolstack trees. Writes there can change what dom0 and the toolstack believe about other guests.
+func CheckXenstoreWriteForeign() bool {
+	xs, domid, err := openXenstoreHome()
+	if err != nil {
+		log.Printf("[Xen][XenstoreWrite] %v", err)
+		return true
+	}
+	defer xs.Close()
+
+	targets := []string{"/local/domain/0", "/tool", "/vm"}
+	if vmPath, err := xs.Read("vm"); err == nil {
+		targets = append(targets, vmPath)
+	}
+	if domains, err := otherDomains(xs, domid); err == nil {
+		for _, id := range domains {
+			if id != 0 {
+				targets = append(targets, fmt.Sprintf("/local/domain/%d", id))
+			}
+		}
+	}
+
+	passed := true
+	for _, target := range targets {
+		if probeXenstoreWrite(xs, target) {
+			log.Printf("[Xen][XenstoreWrite] WARNING: wrote %s/%s outside our own domain", target, xenstoreProbeKey)
+			passed = false
+		}
+	}
+	return passed
+}
+
+// Oldest Xen minor release (4.x) still receiving security support
+const xenOldestSupportedMinor = 18
+
+var xenDevices = []string{
+	"/dev/xen/privcmd",
+	"/dev/xen/gntdev",
+	"/dev/xen/gntalloc",
+	"/dev/xen/evtchn",
+	"/dev/xen/xenbus",
+	"/dev/xen/hypercall",
+}
+
+func readHypervisorFile(name string) string {
+	data, err := os.ReadFile("/sys/hypervisor/" + name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// xenGuestType returns PV, PVH, HVM or unknown
+func xenGuestType() string {
+	if guestType := readHypervisorFile("guest_type"); guestType != "" {
+		return guestType
+	}
+	// Older kernels don't export guest_type, HVM guests carry Xen DMI strings
+	if data, err := os.ReadFile("/sys/class/dmi/id/sys_vendor"); err == nil && strings.Contains(string(data), "Xen") {
+		return "HVM"
+	}
+	// Xen only fills the HVM feature leaf (base+4) for HVM and PVH guests
+	if cpuidHypervisor() == "xen" && cpuidHVLeaf.MaxLeaf >= cpuidHVLeaf.Base+4 {
+		if features, _, _, _ := cpuid(cpuidHVLeaf.Base+4, 0); features != 0 {
+			return "HVM"
+		}
+		return "PV"
+	}
+	return "unknown"
+}
+
+// XenInventory reports the guest mode, Xen version and capabilities, the Xen devices this guest
+// can open and the PV devices attached to it
+func XenInventory() {
+	guestType := xenGuestType()
+	log.Printf("[VM][Xen] Guest type: %s", guestType)
+
+	if data, err := os.ReadFile("/proc/xen/capabilities"); err == nil && strings.Contains(string(data), "control_d") {
+		log.Println("[VM][Xen] WARNING: /proc/xen/capabilities contains control_d, this is the control domain (dom0)")
+	}
+
+	major, minor := readHypervisorFile("version/major"), readHypervisorFile("version/minor")
+	if major != "" {
+		log.Printf("[VM][Xen] Xen version %s.%s%s", major, minor, readHypervisorFile("version/extra"))
+		for _, name := range []string{"properties/capabilities", "properties/changeset", "properties/features", "compilation/compiled_by", "compilation/compile_date", "compilation/compiler"} {
+			if value := readHypervisorFile(name); value != "" {
+				log.Printf("[VM][Xen] %s: %s", name, value)
+			}
+		}
+
+		majorNum, _ := strconv.Atoi(major)
+		minorNum, _ := strconv.Atoi(minor)
+		if majorNum < 4 || (majorNum == 4 && minorNum < xenOldestSupportedMinor) {
+			log.Printf("[VM][Xen] WARNING: Xen %s.%s is out of security support (oldest supported 4.%d)", major, minor, xenOldestSupportedMinor)
+			if guestType == "PV" {
+				log.Println("[VM][Xen] WARNING: PV guest on an unsupported Xen, PV guests are exposed to the largest share of Xen security advisories")
+			}
+		}
+	}
+	if guestType == "PV" {
+		log.Println("[VM][Xen] PV guest: page tables are validated by the hypervisor rather than isolated by hardware")
+	}
+
+	if allowCheck("xen-device-open", ImpactActiveLocal) {
+		for _, dev := range xenDevices {
+			if _, err := os.Stat(dev); err != nil {
+				continue
+			}
+			if dryRunSkip("open", dev, "open read-write and close") {
+				continue
+			}
+			file, err := os.OpenFile(dev, os.O_RDWR, 0)
+			if err != nil {
+				log.Printf("[VM][Xen] %s present, not openable: %v", dev, err)
+				continue
+			}
+			file.Close()
+			if dev == "/dev/xen/privcmd" || dev == "/dev/xen/hypercall" {
+				log.Printf("[VM][Xen] WARNING: %s is openable, hypercalls can be issued from userspace", dev)
+			} else {
+				log.Printf("[VM][Xen] %s is openable", dev)
+			}
+		}
+	}
+
+	if entries, err := os.ReadDir("/sys/bus/xen/devices"); err == nil {
+		for _, entry := range entries {
+			dir := "/sys/bus/xen/devices/" + entry.Name()
+			devtype, _ := os.ReadFile(dir + "/devtype")
+			nodename, _ := os.ReadFile(dir + "/nodename")
+			log.Printf("[VM][Xen] PV device %s (%s) %s", entry.Name(), strings.TrimSpace(string(devtype)), strings.TrimSpace(string(nodename)))
+		}
+	}
+	if xs, err := openXenstore(); err == nil {
+		reportXenBackends(xs)
+		xs.Close()
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// xenstore wire protocol message types (xen/include/public/io/xs_wire.h)
+const (
+	xsDirectory = 1
+	xsRead      = 2
+	xsGetPerms  = 3
+	xsWrite     = 11
+	xsRm        = 13
+	xsError     = 16
+)
+
+// xenstorePerm is one entry of a node's permission list. The first entry names the owner and
+// the default access for every other domain, later entries grant access to specific domains.
+type xenstorePerm struct {
+	Domain int
+	Access byte // 'n' none, 'r' read, 'w' write, 'b' both
+}
+
+// xenstoreClient talks to xenstored through the kernel's xenbus device
+type xenstoreClient struct {
+	mu    sync.Mutex
+	dev   *os.File
+	reqID uint32
+}
+
+func openXenstore() (*xenstoreClient, error) {
+	var lastErr error
+	for _, path := range []string{"/dev/xen/xenbus", "/proc/xen/xenbus"} {
+		dev, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err == nil {
+			return &xenstoreClient{dev: dev}, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to open xenbus: %w", lastErr)
+}
+
+func (x *xenstoreClient) Close() error {
+	return x.dev.Close()
+}
+
+// request sends one message (header: type, request ID, transaction ID, length) and returns the reply payload
+func (x *xenstoreClient) request(op uint32, payload []byte) ([]byte, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.reqID++
+	msg := make([]byte, 16+len(payload))
+	binary.LittleEndian.PutUint32(msg[0:], op)
+	binary.LittleEndian.PutUint32(msg[4:], x.reqID)
+	binary.LittleEndian.PutUint32(msg[8:], 0)
+	binary.LittleEndian.PutUint32(msg[12:], uint32(len(payload)))
+	copy(msg[16:], payload)
+	if _, err := x.dev.Write(msg); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(x.dev, header); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.LittleEndian.Uint32(header[12:]))
+	if _, err := io.ReadFull(x.dev, reply); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(header[0:]) == xsError {
+		return nil, fmt.Errorf("xenstore: %s", strings.TrimRight(string(reply), "\x00"))
+	}
+	return reply, nil
+}
+
+// Read returns the value of a node. Relative paths are relative to our domain's home (/local/domain/<id>).
+func (x *xenstoreClient) Read(path string) (string, error) {
+	reply, err := x.request(xsRead, append([]byte(path), 0))
+	return string(reply), err
+}
+
+// Directory lists the children of a node
+func (x *xenstoreClient) Directory(path string) ([]string, error) {
+	reply, err := x.request(xsDirectory, append([]byte(path), 0))
+	if err != nil {
+		return nil, err
+	}
+	var children []string
+	for _, name := range bytes.Split(bytes.TrimRight(reply, "\x00"), []byte{0}) {
+		if len(name) > 0 {
+			children = append(children, string(name))
+		}
+	}
+	return children, nil
+}
+
+// GetPerms returns the permission list of a node
+func (x *xenstoreClient) GetPerms(path string) ([]xenstorePerm, error) {
+	reply, err := x.request(xsGetPerms, append([]byte(path), 0))
+	if err != nil {
+		return nil, err
+	}
+	var perms []xenstorePerm
+	for _, entry := range bytes.Split(bytes.TrimRight(reply, "\x00"), []byte{0}) {
+		if len(entry) < 2 {
+			continue
+		}
+		domain, err := strconv.Atoi(string(entry[1:]))
+		if err != nil {
+			continue
+		}
+		perms = append(perms, xenstorePerm{domain, entry[0]})
+	}
+	return perms, nil
+}
+
+// Write sets the value of a node, creating it (and missing parents) when needed
+func (x *xenstoreClient) Write(path, value string) error {
+	payload := append(append([]byte(path), 0), value...)
+	_, err := x.request(xsWrite, payload)
+	return err
+}
+
+// Rm removes a node and its children
+func (x *xenstoreClient) Rm(path string) error {
+	_, err := x.request(xsRm, append([]byte(path), 0))
+	return err
+}
+
+// DomID returns our own domain ID
+func (x *xenstoreClient) DomID() (int, error) {
+	value, err := x.Read("domid")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(value))
+}
+
+// xenstoreWritable reports whether domain may write a node with the given permissions
+func xenstoreWritable(perms []xenstorePerm, domain int) bool {
+	if len(perms) == 0 {
+		return false
+	}
+	if perms[0].Domain == domain {
+		return true
+	}
+	for _, p := range perms[1:] {
+		if p.Domain == domain {
+			return p.Access == 'w' || p.Access == 'b'
+		}
+	}
+	return perms[0].Access == 'w' || perms[0].Access == 'b'
+}
+
+func formatXenstorePerms(perms []xenstorePerm) string {
+	parts := make([]string, 0, len(perms))
+	for _, p := range perms {
+		parts = append(parts, fmt.Sprintf("%c%d", p.Access, p.Domain))
+	}
+	return strings.Join(parts, ",")
+}